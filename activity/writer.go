@@ -0,0 +1,199 @@
+// Package activity writes the audit trail to the activity_logs table. Entries
+// are queued in memory and inserted in batches by a single goroutine so that
+// recording one never blocks a request on the database.
+package activity
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/leopardquick/zssf/logging"
+	"github.com/leopardquick/zssf/model"
+	"github.com/leopardquick/zssf/store"
+	"github.com/leopardquick/zssf/tracing"
+)
+
+const (
+	defaultQueueSize     = 1024
+	defaultBatchSize     = 100
+	defaultFlushInterval = time.Second
+	writeTimeout         = 5 * time.Second
+)
+
+// Options tunes a Writer. Zero values use the defaults.
+type Options struct {
+	// QueueSize bounds the entries waiting to be written. When the queue is
+	// full new entries are dropped and counted rather than blocking the
+	// request.
+	QueueSize int
+	// BatchSize entries are written per INSERT.
+	BatchSize int
+	// FlushInterval is the longest an entry waits for a batch to fill.
+	FlushInterval time.Duration
+	Logger        *slog.Logger
+}
+
+// Stats is a snapshot of a Writer's queue and counters.
+type Stats struct {
+	Queued   int
+	Capacity int
+	Written  uint64
+	Dropped  uint64
+	Failed   uint64
+}
+
+// Writer queues activity logs and writes them to a store in batches.
+type Writer struct {
+	store         store.ActivityLogStore
+	batchSize     int
+	flushInterval time.Duration
+	logger        *slog.Logger
+
+	mu     sync.RWMutex
+	closed bool
+	queue  chan store.ActivityLog
+	done   chan struct{}
+
+	written atomic.Uint64
+	dropped atomic.Uint64
+	failed  atomic.Uint64
+}
+
+// NewWriter starts a Writer for s. Close must be called to flush the queue.
+func NewWriter(s store.ActivityLogStore, opts Options) *Writer {
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = defaultQueueSize
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultBatchSize
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = defaultFlushInterval
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+
+	w := &Writer{
+		store:         s,
+		batchSize:     opts.BatchSize,
+		flushInterval: opts.FlushInterval,
+		logger:        opts.Logger,
+		queue:         make(chan store.ActivityLog, opts.QueueSize),
+		done:          make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// Record queues entry, stamped with the request and trace IDs on ctx. It
+// never blocks: once the queue is full or the Writer is closed the entry is
+// logged and dropped.
+func (w *Writer) Record(ctx context.Context, entry model.ActivityLog) {
+	log := store.ActivityLog{
+		UserID:    entry.UserID,
+		RequestID: middleware.GetReqID(ctx),
+		TraceID:   tracing.TraceID(ctx),
+		Message:   entry.LogMessage,
+		CreatedAt: time.Now(),
+	}
+
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if !w.closed {
+		select {
+		case w.queue <- log:
+			return
+		default:
+		}
+	}
+
+	w.dropped.Add(1)
+	w.logger.WarnContext(ctx, "activity log dropped", logging.KeyUserID, entry.UserID, "message", entry.LogMessage)
+}
+
+// Close stops accepting entries and waits until the queue has been written or
+// ctx ends.
+func (w *Writer) Close(ctx context.Context) error {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+	w.mu.Unlock()
+
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (w *Writer) Stats() Stats {
+	return Stats{
+		Queued:   len(w.queue),
+		Capacity: cap(w.queue),
+		Written:  w.written.Load(),
+		Dropped:  w.dropped.Load(),
+		Failed:   w.failed.Load(),
+	}
+}
+
+func (w *Writer) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]store.ActivityLog, 0, w.batchSize)
+	for {
+		select {
+		case log, ok := <-w.queue:
+			if !ok {
+				w.flush(batch)
+				return
+			}
+			batch = append(batch, log)
+			if len(batch) >= w.batchSize {
+				w.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			w.flush(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+// flush writes batch. A failed batch is written to the log instead so the
+// entries are not lost outright.
+func (w *Writer) flush(batch []store.ActivityLog) {
+	if len(batch) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), writeTimeout)
+	defer cancel()
+
+	if err := w.store.CreateBatch(ctx, batch); err != nil {
+		w.failed.Add(uint64(len(batch)))
+		w.logger.Error("error writing activity logs", "count", len(batch), logging.KeyError, err)
+		for _, log := range batch {
+			w.logger.Info("activity",
+				logging.KeyUserID, log.UserID,
+				logging.KeyRequestID, log.RequestID,
+				logging.KeyTraceID, log.TraceID,
+				"message", log.Message,
+			)
+		}
+		return
+	}
+
+	w.written.Add(uint64(len(batch)))
+}
@@ -0,0 +1,258 @@
+// Package billgateway talks to the control-number bill gateway at BASE_URL.
+package billgateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/leopardquick/zssf/model"
+	"github.com/leopardquick/zssf/outbound"
+	"github.com/leopardquick/zssf/setup"
+)
+
+// StatusSuccess is the statusId the gateway returns for a successful call.
+const StatusSuccess = "2000"
+
+const (
+	defaultQueryTimeout   = 20 * time.Second
+	defaultPaymentTimeout = 40 * time.Second
+	defaultStatusTimeout  = 20 * time.Second
+)
+
+var (
+	// ErrNotSent means the request never reached the gateway, so a payment
+	// cannot have been processed.
+	ErrNotSent = errors.New("request not sent to bill gateway")
+	// ErrOutcomeUnknown means the request may have reached the gateway but
+	// no usable response came back.
+	ErrOutcomeUnknown = errors.New("bill gateway outcome unknown")
+	// ErrRejected matches every *StatusError.
+	ErrRejected = errors.New("bill gateway rejected request")
+)
+
+// StatusError is returned when the gateway answers with a statusId other than
+// StatusSuccess.
+type StatusError struct {
+	StatusID      string
+	StatusMessage string
+}
+
+func (e *StatusError) Error() string {
+	if e.StatusMessage == "" {
+		return fmt.Sprintf("bill gateway returned status %q", e.StatusID)
+	}
+	return fmt.Sprintf("bill gateway returned status %q: %s", e.StatusID, e.StatusMessage)
+}
+
+func (e *StatusError) Is(target error) bool {
+	return target == ErrRejected
+}
+
+// Client is the bill gateway API used by the control-number endpoints.
+//
+// Each method returns the decoded gateway response alongside a *StatusError
+// when the gateway rejected the request, so callers can still pass the
+// gateway's answer on.
+type Client interface {
+	QueryBill(ctx context.Context, controlNo, requestID string) (model.EnquireResponse, error)
+	// PostPayment signs and submits payment. Errors wrapping ErrNotSent are
+	// safe to treat as failed; any other non-StatusError leaves the outcome
+	// unknown.
+	PostPayment(ctx context.Context, payment model.PaymentRequest) (model.ControlNumberPaymentResponse, error)
+	PaymentStatus(ctx context.Context, requestID string) (model.ControlNumberPaymentResponse, error)
+}
+
+// HTTPClient calls the gateway at BaseURL, signing every request with the
+// channel password from Secrets.
+type HTTPClient struct {
+	HTTP           *http.Client
+	BaseURL        string
+	ChannelCode    string
+	Secrets        setup.SecretProvider
+	QueryTimeout   time.Duration
+	PaymentTimeout time.Duration
+	StatusTimeout  time.Duration
+}
+
+func New(baseURL, channelCode string, secrets setup.SecretProvider, client *http.Client) *HTTPClient {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &HTTPClient{
+		HTTP:           client,
+		BaseURL:        baseURL,
+		ChannelCode:    channelCode,
+		Secrets:        secrets,
+		QueryTimeout:   defaultQueryTimeout,
+		PaymentTimeout: defaultPaymentTimeout,
+		StatusTimeout:  defaultStatusTimeout,
+	}
+}
+
+func (c *HTTPClient) QueryBill(ctx context.Context, controlNo, requestID string) (model.EnquireResponse, error) {
+	securityCode, err := c.securityCode(ctx, requestID)
+	if err != nil {
+		return model.EnquireResponse{}, err
+	}
+
+	var response model.EnquireResponse
+	// a bill query has no side effects, so it may be retried
+	err = c.post(outbound.Idempotent(ctx), c.QueryTimeout, "bill/query", model.EnquireRequest{
+		ControlNo:    controlNo,
+		RequestId:    requestID,
+		ChannelCode:  c.ChannelCode,
+		SecurityCode: securityCode,
+	}, &response)
+	if err != nil {
+		return model.EnquireResponse{}, err
+	}
+
+	if response.StatusId != StatusSuccess {
+		return response, &StatusError{StatusID: response.StatusId, StatusMessage: response.StatusMessage}
+	}
+
+	return response, nil
+}
+
+func (c *HTTPClient) PostPayment(ctx context.Context, payment model.PaymentRequest) (model.ControlNumberPaymentResponse, error) {
+	securityCode, err := c.securityCode(ctx, payment.RequestID)
+	if err != nil {
+		return model.ControlNumberPaymentResponse{}, fmt.Errorf("%w: %v", ErrNotSent, err)
+	}
+
+	payment.ChannelCode = c.ChannelCode
+	payment.SecurityCode = securityCode
+
+	// never marked idempotent: a retried post could pay the bill twice
+	var response model.ControlNumberPaymentResponse
+	if err := c.post(ctx, c.PaymentTimeout, "payment/post", payment, &response); err != nil {
+		return model.ControlNumberPaymentResponse{}, err
+	}
+
+	// without a statusId the gateway has not said whether it took the payment
+	if response.StatusId == "" {
+		return model.ControlNumberPaymentResponse{}, fmt.Errorf("%w: payment response without statusId", ErrOutcomeUnknown)
+	}
+
+	if response.StatusId != StatusSuccess {
+		return response, &StatusError{StatusID: response.StatusId, StatusMessage: response.StatusMessage}
+	}
+
+	return response, nil
+}
+
+func (c *HTTPClient) PaymentStatus(ctx context.Context, requestID string) (model.ControlNumberPaymentResponse, error) {
+	securityCode, err := c.securityCode(ctx, requestID)
+	if err != nil {
+		return model.ControlNumberPaymentResponse{}, err
+	}
+
+	var response model.ControlNumberPaymentResponse
+	err = c.post(outbound.Idempotent(ctx), c.StatusTimeout, "payment/status", model.PaymentStatusRequest{
+		RequestID:    requestID,
+		ChannelCode:  c.ChannelCode,
+		SecurityCode: securityCode,
+	}, &response)
+	if err != nil {
+		return model.ControlNumberPaymentResponse{}, err
+	}
+
+	// a reply without a statusId says nothing about the payment
+	if response.StatusId == "" {
+		return model.ControlNumberPaymentResponse{}, fmt.Errorf("%w: payment status response without statusId", ErrOutcomeUnknown)
+	}
+
+	if response.StatusId != StatusSuccess {
+		return response, &StatusError{StatusID: response.StatusId, StatusMessage: response.StatusMessage}
+	}
+
+	return response, nil
+}
+
+// post sends payload to path and decodes the reply into out. Failures before
+// the request left the service wrap ErrNotSent; failures after, including a
+// non-2xx reply such as a proxy error page, wrap ErrOutcomeUnknown.
+func (c *HTTPClient) post(ctx context.Context, timeout time.Duration, path string, payload, out any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrNotSent, err)
+	}
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrNotSent, err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := c.HTTP.Do(request)
+	if err != nil {
+		if notSent(err) {
+			return fmt.Errorf("%w: %w", ErrNotSent, err)
+		}
+		return fmt.Errorf("%w: %w", ErrOutcomeUnknown, err)
+	}
+	defer response.Body.Close()
+
+	responseBody, err := io.ReadAll(response.Body)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrOutcomeUnknown, err)
+	}
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("%w: http %d", ErrOutcomeUnknown, response.StatusCode)
+	}
+
+	if err := json.Unmarshal(responseBody, out); err != nil {
+		return fmt.Errorf("%w: decode response (http %d): %v", ErrOutcomeUnknown, response.StatusCode, err)
+	}
+
+	return nil
+}
+
+func (c *HTTPClient) securityCode(ctx context.Context, requestID string) (string, error) {
+	channelPassword, err := c.Secrets.Secret(ctx, setup.SecretSecurityCode)
+	if err != nil {
+		return "", fmt.Errorf("load channel password: %w", err)
+	}
+
+	return SecurityCode(c.ChannelCode, requestID, channelPassword), nil
+}
+
+// SecurityCode signs a gateway request:
+// Base64(hex(SHA256(channelCode + requestID + Base64(channelPassword)))).
+func SecurityCode(channelCode, requestID, channelPassword string) string {
+	inputString := channelCode + requestID + base64.StdEncoding.EncodeToString([]byte(channelPassword))
+
+	hash := sha256.Sum256([]byte(inputString))
+
+	return base64.StdEncoding.EncodeToString([]byte(hex.EncodeToString(hash[:])))
+}
+
+// notSent reports whether err happened before the request reached the
+// gateway, in which case the payment cannot have been processed. That
+// includes requests the outbound transport refused to send.
+func notSent(err error) bool {
+	if errors.Is(err, outbound.ErrCircuitOpen) || errors.Is(err, outbound.ErrConcurrencyLimit) {
+		return true
+	}
+
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
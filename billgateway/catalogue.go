@@ -0,0 +1,133 @@
+package billgateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	LanguageEnglish = "en"
+	LanguageSwahili = "sw"
+)
+
+// StatusInfo says how a gateway statusId is reported to our clients.
+type StatusInfo struct {
+	HTTPStatus int               `json:"http_status" yaml:"http_status"`
+	Code       string            `json:"code" yaml:"code"`
+	Retryable  bool              `json:"retryable" yaml:"retryable"`
+	Messages   map[string]string `json:"messages" yaml:"messages"`
+	// PaymentFailed marks a statusId that, returned by payment/status, means
+	// the payment definitely did not go through.
+	PaymentFailed bool `json:"payment_failed" yaml:"payment_failed"`
+}
+
+// Message returns the user message in lang, falling back to English.
+func (s StatusInfo) Message(lang string) string {
+	if message := s.Messages[lang]; message != "" {
+		return message
+	}
+	return s.Messages[LanguageEnglish]
+}
+
+// Catalogue maps gateway statusIds to StatusInfo. A nil *Catalogue behaves
+// like DefaultCatalogue.
+type Catalogue struct {
+	Statuses map[string]StatusInfo `json:"statuses" yaml:"statuses"`
+	Default  StatusInfo            `json:"default" yaml:"default"`
+}
+
+// DefaultCatalogue knows no statusIds and reports every rejection as
+// GATEWAY_REJECTED.
+func DefaultCatalogue() *Catalogue {
+	return &Catalogue{
+		Statuses: map[string]StatusInfo{},
+		Default: StatusInfo{
+			HTTPStatus: http.StatusBadRequest,
+			Code:       "GATEWAY_REJECTED",
+			Messages: map[string]string{
+				LanguageEnglish: "The bill service could not complete the request.",
+				LanguageSwahili: "Huduma ya malipo ya bili imeshindwa kukamilisha ombi.",
+			},
+		},
+	}
+}
+
+// LoadCatalogue reads a YAML (.yaml/.yml) or JSON (.json) catalogue. Fields
+// missing from the file's default entry are taken from DefaultCatalogue.
+func LoadCatalogue(path string) (*Catalogue, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read gateway status catalogue: %w", err)
+	}
+
+	var catalogue Catalogue
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(data, &catalogue)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &catalogue)
+	default:
+		return nil, fmt.Errorf("unsupported gateway status catalogue type %q", filepath.Ext(path))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse gateway status catalogue: %w", err)
+	}
+
+	fallback := DefaultCatalogue().Default
+	if catalogue.Default.HTTPStatus == 0 {
+		catalogue.Default.HTTPStatus = fallback.HTTPStatus
+	}
+	if catalogue.Default.Code == "" {
+		catalogue.Default.Code = fallback.Code
+	}
+	if catalogue.Default.Messages[LanguageEnglish] == "" {
+		catalogue.Default.Messages = fallback.Messages
+	}
+
+	for statusID, info := range catalogue.Statuses {
+		if info.Code == "" {
+			return nil, fmt.Errorf("gateway status %q has no code", statusID)
+		}
+		if info.HTTPStatus < 400 || info.HTTPStatus > 599 {
+			return nil, fmt.Errorf("gateway status %q has invalid http_status %d", statusID, info.HTTPStatus)
+		}
+	}
+
+	return &catalogue, nil
+}
+
+// Lookup returns the entry for statusID. Unknown statusIds get the default
+// entry, with the gateway's own message used for every language when it sent
+// one.
+func (c *Catalogue) Lookup(statusID, gatewayMessage string) StatusInfo {
+	if c == nil {
+		c = DefaultCatalogue()
+	}
+
+	if info, ok := c.Statuses[statusID]; ok {
+		return info
+	}
+
+	info := c.Default
+	if gatewayMessage != "" {
+		info.Messages = map[string]string{LanguageEnglish: gatewayMessage}
+	}
+	return info
+}
+
+// PaymentFailed reports whether a payment/status answer with statusID proves
+// the payment failed. Only statusIds listed with payment_failed do: anything
+// else, such as an unknown request or a bad security code, says nothing about
+// the payment.
+func (c *Catalogue) PaymentFailed(statusID string) bool {
+	if c == nil {
+		return false
+	}
+	return c.Statuses[statusID].PaymentFailed
+}
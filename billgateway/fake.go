@@ -0,0 +1,170 @@
+package billgateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/leopardquick/zssf/model"
+)
+
+// FakeServer is an in-process bill gateway for tests. It serves bill/query,
+// payment/post and payment/status, checks security codes, and records every
+// payment it receives. Point an HTTPClient at BaseURL() with the same channel
+// code and password.
+type FakeServer struct {
+	*httptest.Server
+
+	ChannelCode     string
+	ChannelPassword string
+
+	mu       sync.Mutex
+	bills    map[string]model.ApiResponse
+	payments map[string]model.ControlNumberPaymentResponse
+	failures map[string]StatusError
+	Received []model.PaymentRequest
+}
+
+func NewFakeServer(channelCode, channelPassword string) *FakeServer {
+	f := &FakeServer{
+		ChannelCode:     channelCode,
+		ChannelPassword: channelPassword,
+		bills:           make(map[string]model.ApiResponse),
+		payments:        make(map[string]model.ControlNumberPaymentResponse),
+		failures:        make(map[string]StatusError),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/bill/query", f.queryBill)
+	mux.HandleFunc("/payment/post", f.postPayment)
+	mux.HandleFunc("/payment/status", f.paymentStatus)
+	f.Server = httptest.NewServer(mux)
+
+	return f
+}
+
+// BaseURL returns the base URL to use as BASE_URL, including the trailing slash.
+func (f *FakeServer) BaseURL() string {
+	return f.Server.URL + "/"
+}
+
+// AddBill makes bill answerable by bill/query under its control number.
+func (f *FakeServer) AddBill(bill model.ApiResponse) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.bills[bill.ControlNo] = bill
+}
+
+// Reject makes calls for the given control number answer with statusID.
+func (f *FakeServer) Reject(controlNo, statusID, statusMessage string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures[controlNo] = StatusError{StatusID: statusID, StatusMessage: statusMessage}
+}
+
+func (f *FakeServer) queryBill(w http.ResponseWriter, r *http.Request) {
+	var request model.EnquireRequest
+	if !f.decode(w, r, &request) {
+		return
+	}
+	if !f.signed(w, request.ChannelCode, request.RequestId, request.SecurityCode) {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if failure, ok := f.failures[request.ControlNo]; ok {
+		writeJSON(w, model.EnquireResponse{StatusId: failure.StatusID, StatusMessage: failure.StatusMessage})
+		return
+	}
+
+	bill, ok := f.bills[request.ControlNo]
+	if !ok {
+		writeJSON(w, model.EnquireResponse{StatusId: "4004", StatusMessage: "bill not found"})
+		return
+	}
+
+	bill.RequestId = request.RequestId
+	writeJSON(w, model.EnquireResponse{StatusId: StatusSuccess, StatusMessage: "success", Data: bill})
+}
+
+func (f *FakeServer) postPayment(w http.ResponseWriter, r *http.Request) {
+	var request model.PaymentRequest
+	if !f.decode(w, r, &request) {
+		return
+	}
+	if !f.signed(w, request.ChannelCode, request.RequestID, request.SecurityCode) {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.Received = append(f.Received, request)
+
+	response := model.ControlNumberPaymentResponse{StatusId: StatusSuccess, StatusMessage: "success"}
+	if failure, ok := f.failures[request.ControlNo]; ok {
+		response = model.ControlNumberPaymentResponse{StatusId: failure.StatusID, StatusMessage: failure.StatusMessage}
+	}
+
+	response.Data = model.ControlNumberData{
+		ControlNo:       request.ControlNo,
+		RequestId:       request.RequestID,
+		ApiResponseDate: time.Now().Format(time.RFC3339),
+		DebitAccount:    request.DebitAccount,
+		CreditAccount:   request.CreditAccount,
+		Amount:          request.Amount.Number(),
+		Currency:        request.Currency,
+	}
+	if response.StatusId == StatusSuccess {
+		response.Data.GatewayRefId = "FAKE" + request.RequestID
+		response.Data.ReceiptNo = "RCPT" + request.RequestID
+	}
+
+	f.payments[request.RequestID] = response
+	writeJSON(w, response)
+}
+
+func (f *FakeServer) paymentStatus(w http.ResponseWriter, r *http.Request) {
+	var request model.PaymentStatusRequest
+	if !f.decode(w, r, &request) {
+		return
+	}
+	if !f.signed(w, request.ChannelCode, request.RequestID, request.SecurityCode) {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	response, ok := f.payments[request.RequestID]
+	if !ok {
+		writeJSON(w, model.ControlNumberPaymentResponse{StatusId: "4004", StatusMessage: "payment not found"})
+		return
+	}
+	writeJSON(w, response)
+}
+
+func (f *FakeServer) decode(w http.ResponseWriter, r *http.Request, out any) bool {
+	if err := json.NewDecoder(r.Body).Decode(out); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func (f *FakeServer) signed(w http.ResponseWriter, channelCode, requestID, securityCode string) bool {
+	if channelCode != f.ChannelCode || securityCode != SecurityCode(f.ChannelCode, requestID, f.ChannelPassword) {
+		writeJSON(w, model.EnquireResponse{StatusId: "4001", StatusMessage: "invalid security code"})
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, payload any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(payload)
+}
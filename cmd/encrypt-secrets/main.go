@@ -0,0 +1,81 @@
+// Command encrypt-secrets seals a JSON object of secrets for the encrypted
+// secrets provider. It reads the object from the file named by -in (standard
+// input by default), encrypts it with the base64-encoded 32-byte key in
+// SECRETS_MASTER_KEY and writes the result to -out (standard output by
+// default):
+//
+//	SECRETS_MASTER_KEY=... go run ./cmd/encrypt-secrets -in secrets.json -out secrets.enc
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/leopardquick/zssf/setup"
+)
+
+func main() {
+	in := flag.String("in", "", "JSON file of secret name to value (default standard input)")
+	out := flag.String("out", "", "file to write the sealed secrets to (default standard output)")
+	flag.Parse()
+
+	if err := run(*in, *out); err != nil {
+		fmt.Fprintln(os.Stderr, "encrypt-secrets:", err)
+		os.Exit(1)
+	}
+}
+
+func run(in, out string) error {
+	key, err := base64.StdEncoding.DecodeString(os.Getenv("SECRETS_MASTER_KEY"))
+	if err != nil {
+		return fmt.Errorf("decode SECRETS_MASTER_KEY: %w", err)
+	}
+
+	var data []byte
+	if in == "" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(in)
+	}
+	if err != nil {
+		return fmt.Errorf("read secrets: %w", err)
+	}
+
+	var secrets map[string]string
+	if err := json.Unmarshal(data, &secrets); err != nil {
+		return fmt.Errorf("parse secrets: %w", err)
+	}
+
+	sealed, err := setup.EncryptSecrets(key, secrets)
+	if err != nil {
+		return err
+	}
+	sealed = append(sealed, '\n')
+
+	if out == "" {
+		_, err = os.Stdout.Write(sealed)
+		return err
+	}
+
+	// write next to the target and rename so the running service, which
+	// reloads the file when it changes, never reads it half-written
+	temp, err := os.CreateTemp(filepath.Dir(out), filepath.Base(out)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	if _, err := temp.Write(sealed); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), out)
+}
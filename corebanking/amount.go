@@ -0,0 +1,24 @@
+package corebanking
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/leopardquick/zssf/model"
+)
+
+var ErrInvalidAmount = errors.New("invalid amount")
+
+// parseAmount parses a core banking amount such as "Tshs 1,000.50", "$ 20" or
+// "-15.5". The currency prefix core banking puts on display values is
+// dropped; currency is taken from the account instead.
+func parseAmount(value, currency string) (model.Money, error) {
+	raw := strings.TrimLeft(strings.TrimSpace(value), "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz$ ")
+
+	amount, err := model.ParseMoney(raw, currency)
+	if err != nil {
+		return model.Money{}, fmt.Errorf("%w: %q", ErrInvalidAmount, value)
+	}
+	return amount, nil
+}
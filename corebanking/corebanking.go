@@ -0,0 +1,251 @@
+// Package corebanking talks to the core banking account verification service.
+package corebanking
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/leopardquick/zssf/currency"
+	"github.com/leopardquick/zssf/helper"
+	"github.com/leopardquick/zssf/model"
+	"github.com/leopardquick/zssf/outbound"
+	"github.com/leopardquick/zssf/setup"
+)
+
+var (
+	ErrAccountNotFound   = errors.New("account not found")
+	ErrAccountRestricted = errors.New("account is restricted")
+	ErrInsufficientFunds = errors.New("insufficient balance")
+	ErrUnavailable       = errors.New("core banking service unavailable")
+)
+
+// Error carries the message core banking returned with a non-200 response.
+// It unwraps to ErrAccountNotFound when core banking answered 404. The message
+// is never inspected: a "customer not found" or "currency not found" from
+// another status does not mean the account is missing.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("core banking returned %d: %s", e.StatusCode, e.Message)
+}
+
+func (e *Error) Unwrap() error {
+	if e.StatusCode == http.StatusNotFound {
+		return ErrAccountNotFound
+	}
+	return nil
+}
+
+// Client looks up accounts in core banking.
+type Client interface {
+	VerifyAccount(ctx context.Context, accountNumber string) (Account, error)
+}
+
+// Account is an account as reported by core banking, with amounts parsed.
+type Account struct {
+	AccountNumber    string
+	CustomerNumber   string
+	CustomerName     string
+	AccountType      string
+	Status           string
+	Restriction      string
+	Currency         string
+	Balance          model.Money
+	AvailableBalance model.Money
+	BlockedFunds     model.Money
+	MobileNumber     string
+	Email            string
+	NationalNumber   string
+}
+
+// RestrictedError explains why an account cannot be debited. It matches
+// ErrAccountRestricted with errors.Is.
+type RestrictedError struct {
+	Reason string
+}
+
+func (e *RestrictedError) Error() string {
+	return e.Reason
+}
+
+func (e *RestrictedError) Is(target error) bool {
+	return target == ErrAccountRestricted
+}
+
+// CheckDebit rejects a debit of amount when the account is dormant or
+// closed, carries a debit restriction, or cannot cover the amount. The
+// returned error is a *RestrictedError or ErrInsufficientFunds. An amount in
+// a currency other than the account's is treated as a restriction.
+func (a Account) CheckDebit(amount model.Money) error {
+	status := strings.ToUpper(a.Status)
+	switch {
+	case strings.Contains(status, "DORMANT"):
+		return &RestrictedError{Reason: "account is dormant"}
+	case strings.Contains(status, "CLOSED"):
+		return &RestrictedError{Reason: "account is closed"}
+	}
+
+	restriction := strings.ToUpper(a.Restriction)
+	if strings.Contains(restriction, "DEBIT") || strings.Contains(restriction, "BLOCK") {
+		return &RestrictedError{Reason: "account has a debit restriction"}
+	}
+
+	// available_balance may or may not already exclude blocked funds, so
+	// take the lower of the two readings
+	available := a.AvailableBalance
+	if a.BlockedFunds.Sign() > 0 {
+		if unblocked, err := a.Balance.Sub(a.BlockedFunds); err == nil && unblocked.Cmp(available) < 0 {
+			available = unblocked
+		}
+	}
+
+	if _, err := available.Sub(amount); errors.Is(err, model.ErrCurrencyMismatch) {
+		return &RestrictedError{Reason: "account is held in " + a.Currency}
+	}
+
+	if amount.Cmp(available) > 0 {
+		return ErrInsufficientFunds
+	}
+
+	return nil
+}
+
+// HTTPClient calls the account verification endpoint at BaseURL, signing
+// requests with the account verification key from Secrets.
+type HTTPClient struct {
+	HTTP    *http.Client
+	BaseURL string
+	Secrets setup.SecretProvider
+}
+
+func New(baseURL string, secrets setup.SecretProvider, client *http.Client) *HTTPClient {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &HTTPClient{HTTP: client, BaseURL: baseURL, Secrets: secrets}
+}
+
+func (c *HTTPClient) VerifyAccount(ctx context.Context, accountNumber string) (Account, error) {
+	referenceNumber := helper.GenerateReferenceNumber()
+
+	body, err := json.Marshal(model.AccountVerificationRequest{
+		AccountNumber:   accountNumber,
+		ReferenceNumber: referenceNumber,
+	})
+	if err != nil {
+		return Account{}, err
+	}
+
+	// verification is a read, so the outbound transport may retry it
+	req, err := http.NewRequestWithContext(outbound.Idempotent(ctx), http.MethodPost, c.BaseURL+"/service1/account-verification", bytes.NewBuffer(body))
+	if err != nil {
+		return Account{}, err
+	}
+
+	verificationKey, err := c.Secrets.Secret(ctx, setup.SecretAccountVerificationKey)
+	if err != nil {
+		return Account{}, fmt.Errorf("load account verification key: %w", err)
+	}
+
+	// send the inbound request's ID, when there is one, so the call can be
+	// matched to our request logs
+	requestID := middleware.GetReqID(ctx)
+	if requestID == "" {
+		requestID = referenceNumber
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Request-Id", requestID)
+	req.Header.Set("Authorization", verificationKey)
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return Account{}, fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		if resp.StatusCode >= http.StatusInternalServerError {
+			return Account{}, fmt.Errorf("%w: status %d", ErrUnavailable, resp.StatusCode)
+		}
+
+		var errorResponse model.ErrorResponse
+		if err := json.NewDecoder(resp.Body).Decode(&errorResponse); err != nil {
+			return Account{}, fmt.Errorf("decode error response: %w", err)
+		}
+		return Account{}, &Error{StatusCode: resp.StatusCode, Message: errorResponse.Error}
+	}
+
+	var verification model.AccountVerificationRespond
+	if err := json.NewDecoder(resp.Body).Decode(&verification); err != nil {
+		return Account{}, fmt.Errorf("decode account verification response: %w", err)
+	}
+
+	return accountFromVerification(accountNumber, verification)
+}
+
+func accountFromVerification(accountNumber string, v model.AccountVerificationRespond) (Account, error) {
+	code := parseCurrency(v.AccountCurrency)
+
+	balance, err := parseAmount(v.AccountBalance, code)
+	if err != nil {
+		return Account{}, fmt.Errorf("account balance: %w", err)
+	}
+
+	available, err := parseAmount(v.AvailabalBalance, code)
+	if err != nil {
+		return Account{}, fmt.Errorf("available balance: %w", err)
+	}
+
+	blocked := model.Money{Currency: code}
+	if strings.TrimSpace(v.TotalBlockedFund) != "" {
+		if blocked, err = parseAmount(v.TotalBlockedFund, code); err != nil {
+			return Account{}, fmt.Errorf("blocked funds: %w", err)
+		}
+	}
+
+	if v.FullAccountNumber != "" {
+		accountNumber = v.FullAccountNumber
+	}
+
+	return Account{
+		AccountNumber:    accountNumber,
+		CustomerNumber:   v.CustomerNumber,
+		CustomerName:     v.CustomerName,
+		AccountType:      v.AccountType,
+		Status:           v.AccountStatus,
+		Restriction:      v.AccountRestriction,
+		Currency:         code,
+		Balance:          balance,
+		AvailableBalance: available,
+		BlockedFunds:     blocked,
+		MobileNumber:     v.MobileNumber,
+		Email:            v.AccountEmail,
+		NationalNumber:   v.NationalNumber,
+	}, nil
+}
+
+// parseCurrency turns core banking's "<code> <alpha>" form, such as "1 TZS",
+// into the ISO 4217 alpha code. Codes the currency table does not know are
+// passed through upper-cased rather than failing the whole account.
+func parseCurrency(value string) string {
+	if c, err := currency.Parse(value); err == nil {
+		return c.Code
+	}
+
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return ""
+	}
+	return strings.ToUpper(fields[len(fields)-1])
+}
@@ -0,0 +1,43 @@
+package corebanking
+
+import (
+	"context"
+	"sync"
+)
+
+// Fake is an in-memory Client for tests. Accounts not in the map return
+// ErrAccountNotFound; a non-nil Err is returned for every call.
+type Fake struct {
+	mu       sync.Mutex
+	Accounts map[string]Account
+	Err      error
+	Calls    []string
+}
+
+func NewFake(accounts ...Account) *Fake {
+	f := &Fake{Accounts: make(map[string]Account, len(accounts))}
+	for _, account := range accounts {
+		f.Accounts[account.AccountNumber] = account
+	}
+	return f
+}
+
+func (f *Fake) VerifyAccount(ctx context.Context, accountNumber string) (Account, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.Calls = append(f.Calls, accountNumber)
+
+	if err := ctx.Err(); err != nil {
+		return Account{}, err
+	}
+	if f.Err != nil {
+		return Account{}, f.Err
+	}
+
+	account, ok := f.Accounts[accountNumber]
+	if !ok {
+		return Account{}, ErrAccountNotFound
+	}
+	return account, nil
+}
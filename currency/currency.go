@@ -0,0 +1,157 @@
+// Package currency maps the currency codes used by core banking, the bill
+// gateway and API clients to ISO 4217 currencies.
+package currency
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/leopardquick/zssf/model"
+)
+
+var ErrUnknownCurrency = errors.New("unknown currency")
+
+// Currency is an ISO 4217 currency.
+type Currency struct {
+	Code       string // alphabetic code, e.g. "TZS"
+	Numeric    string // numeric code, e.g. "834"
+	Name       string
+	Symbol     string
+	MinorUnits int // decimal places in the minor unit
+}
+
+// currencies are the ISO 4217 currencies the bank holds accounts in or is
+// likely to see from the gateway. model.Money keeps two decimal places, so
+// currencies with three minor units, such as OMR, are deliberately left out.
+var currencies = []Currency{
+	{Code: "TZS", Numeric: "834", Name: "Tanzanian Shilling", Symbol: "TSh", MinorUnits: 2},
+	{Code: "USD", Numeric: "840", Name: "US Dollar", Symbol: "$", MinorUnits: 2},
+	{Code: "EUR", Numeric: "978", Name: "Euro", Symbol: "€", MinorUnits: 2},
+	{Code: "GBP", Numeric: "826", Name: "Pound Sterling", Symbol: "£", MinorUnits: 2},
+	{Code: "KES", Numeric: "404", Name: "Kenyan Shilling", Symbol: "KSh", MinorUnits: 2},
+	{Code: "UGX", Numeric: "800", Name: "Uganda Shilling", Symbol: "USh", MinorUnits: 0},
+	{Code: "RWF", Numeric: "646", Name: "Rwanda Franc", Symbol: "FRw", MinorUnits: 0},
+	{Code: "BIF", Numeric: "108", Name: "Burundi Franc", Symbol: "FBu", MinorUnits: 0},
+	{Code: "ZMW", Numeric: "967", Name: "Zambian Kwacha", Symbol: "K", MinorUnits: 2},
+	{Code: "MWK", Numeric: "454", Name: "Malawi Kwacha", Symbol: "MK", MinorUnits: 2},
+	{Code: "ZAR", Numeric: "710", Name: "Rand", Symbol: "R", MinorUnits: 2},
+	{Code: "AED", Numeric: "784", Name: "UAE Dirham", Symbol: "AED", MinorUnits: 2},
+	{Code: "SAR", Numeric: "682", Name: "Saudi Riyal", Symbol: "SAR", MinorUnits: 2},
+	{Code: "INR", Numeric: "356", Name: "Indian Rupee", Symbol: "₹", MinorUnits: 2},
+	{Code: "CNY", Numeric: "156", Name: "Yuan Renminbi", Symbol: "CN¥", MinorUnits: 2},
+	{Code: "JPY", Numeric: "392", Name: "Yen", Symbol: "JP¥", MinorUnits: 0},
+	{Code: "CHF", Numeric: "756", Name: "Swiss Franc", Symbol: "CHF", MinorUnits: 2},
+	{Code: "CAD", Numeric: "124", Name: "Canadian Dollar", Symbol: "CA$", MinorUnits: 2},
+	{Code: "AUD", Numeric: "036", Name: "Australian Dollar", Symbol: "A$", MinorUnits: 2},
+}
+
+// aliases are non-ISO spellings seen in core banking and gateway data.
+var aliases = map[string]string{
+	"TSH":  "TZS",
+	"TSHS": "TZS",
+	"$":    "USD",
+	"US$":  "USD",
+}
+
+var byCode, byNumeric = index(currencies)
+
+func index(list []Currency) (map[string]Currency, map[string]Currency) {
+	codes := make(map[string]Currency, len(list))
+	numerics := make(map[string]Currency, len(list))
+	for _, c := range list {
+		codes[c.Code] = c
+		numerics[c.Numeric] = c
+	}
+	return codes, numerics
+}
+
+// Lookup finds a currency by its alphabetic or numeric ISO code, ignoring
+// case and surrounding space.
+func Lookup(code string) (Currency, bool) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if alias, ok := aliases[code]; ok {
+		code = alias
+	}
+	if c, ok := byCode[code]; ok {
+		return c, true
+	}
+	if n, err := strconv.Atoi(code); err == nil {
+		c, ok := byNumeric[fmt.Sprintf("%03d", n)]
+		return c, ok
+	}
+	return Currency{}, false
+}
+
+// Parse resolves a currency as written by core banking, such as "1 TZS",
+// "TZS" or "Tshs". When the value carries several fields the last one that
+// resolves wins, since core banking prefixes the alpha code with its own
+// internal number.
+func Parse(value string) (Currency, error) {
+	fields := strings.Fields(value)
+	for i := len(fields) - 1; i >= 0; i-- {
+		if c, ok := Lookup(fields[i]); ok {
+			return c, nil
+		}
+	}
+	return Currency{}, fmt.Errorf("%w: %q", ErrUnknownCurrency, value)
+}
+
+// Valid reports whether code is a known ISO 4217 alphabetic code.
+func Valid(code string) bool {
+	_, ok := byCode[strings.ToUpper(strings.TrimSpace(code))]
+	return ok
+}
+
+// Fits reports whether amount can be expressed in c's minor units, e.g. a
+// UGX amount may not carry cents.
+func (c Currency) Fits(amount model.Money) bool {
+	if c.MinorUnits >= 2 {
+		return true
+	}
+	step := int64(1)
+	for i := c.MinorUnits; i < 2; i++ {
+		step *= 10
+	}
+	return amount.Minor%step == 0
+}
+
+// Format renders amount for display with the currency symbol, thousands
+// separators and c's minor units, e.g. "TSh 1,000.50" or "USh 25,000".
+// Without a symbol the code is used.
+func (c Currency) Format(amount model.Money) string {
+	symbol := c.Symbol
+	if symbol == "" {
+		symbol = c.Code
+	}
+	return strings.TrimSpace(symbol + " " + group(amount, c.MinorUnits))
+}
+
+// group writes amount with comma thousands separators and places decimals.
+func group(amount model.Money, places int) string {
+	whole, fraction, _ := strings.Cut(amount.String(), ".")
+
+	sign := ""
+	if strings.HasPrefix(whole, "-") {
+		sign, whole = "-", whole[1:]
+	}
+
+	var b strings.Builder
+	for i, r := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(r)
+	}
+
+	switch {
+	case places <= 0:
+		return sign + b.String()
+	case places > len(fraction):
+		fraction += strings.Repeat("0", places-len(fraction))
+	default:
+		fraction = fraction[:places]
+	}
+	return sign + b.String() + "." + fraction
+}
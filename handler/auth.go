@@ -0,0 +1,267 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/leopardquick/zssf/logging"
+	"github.com/leopardquick/zssf/store"
+)
+
+const (
+	apiKeyHeader    = "X-Api-Key"
+	clientIDHeader  = "X-Client-Id"
+	timestampHeader = "X-Timestamp"
+	nonceHeader     = "X-Nonce"
+	signatureHeader = "X-Signature"
+	userIDHeader    = "X-User-Id"
+
+	defaultMaxClockSkew = 5 * time.Minute
+)
+
+// Authenticator identifies the caller and stores it on the request context.
+// Mobile app users present a JWT bearer token; channels present a static API
+// key or an HMAC-SHA256 signature over the request.
+type Authenticator struct {
+	Clients      store.APIClientStore
+	JWT          *JWTVerifier
+	MaxClockSkew time.Duration
+	Now          func() time.Time
+}
+
+func NewAuthenticator(clients store.APIClientStore) *Authenticator {
+	return &Authenticator{
+		Clients:      clients,
+		MaxClockSkew: defaultMaxClockSkew,
+		Now:          time.Now,
+	}
+}
+
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token, ok := bearerToken(r); ok && a.JWT != nil {
+			claims, err := a.JWT.Verify(token)
+			if err != nil {
+				writeError(w, r, newAPIError(http.StatusUnauthorized, CodeUnauthorized, err.Error()))
+				return
+			}
+
+			logging.AddAttrs(r.Context(), slog.String(logging.KeyUserID, claims.Subject))
+			ctx := context.WithValue(r.Context(), userKey, claims.Subject)
+			ctx = context.WithValue(ctx, accountKey, claims.Accounts)
+			ctx = context.WithValue(ctx, deviceUniqueIDKey, claims.DeviceUniqueID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
+		client, err := a.authenticateClient(r)
+		if err != nil {
+			writeError(w, r, newAPIError(http.StatusUnauthorized, CodeUnauthorized, err.Error()))
+			return
+		}
+
+		userID, err := a.clientUser(r, client)
+		if err != nil {
+			writeError(w, r, asAPIError(err))
+			return
+		}
+
+		logging.AddAttrs(r.Context(), slog.String(logging.KeyUserID, userID), slog.String("channel", client.Channel))
+		ctx := context.WithValue(r.Context(), userKey, userID)
+		ctx = context.WithValue(ctx, channelKey, client.Channel)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// clientUser returns the user a channel acts for: the user named in
+// X-User-Id, which must be linked to the client unless it may assert any
+// user, or else the client itself.
+func (a *Authenticator) clientUser(r *http.Request, client store.APIClient) (string, error) {
+	userID := r.Header.Get(userIDHeader)
+	switch {
+	case userID == "":
+		return client.ClientID, nil
+	case userID == client.ClientID || client.AssertAnyUser:
+		return userID, nil
+	}
+
+	linked, err := a.Clients.ActsFor(r.Context(), client.ClientID, userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error checking client user", logging.KeyError, err)
+		return "", internalError("failed to verify user")
+	}
+	if !linked {
+		return "", newAPIError(http.StatusForbidden, CodeUnauthorized, "client may not act for this user")
+	}
+
+	return userID, nil
+}
+
+func (a *Authenticator) authenticateClient(r *http.Request) (store.APIClient, error) {
+	if a.Clients == nil {
+		return store.APIClient{}, errors.New("authentication is not configured")
+	}
+
+	if r.Header.Get(signatureHeader) != "" {
+		return a.verifySignature(r)
+	}
+
+	if apiKey := r.Header.Get(apiKeyHeader); apiKey != "" {
+		return a.verifyAPIKey(r.Context(), apiKey)
+	}
+
+	return store.APIClient{}, errors.New("missing credentials")
+}
+
+func (a *Authenticator) verifyAPIKey(ctx context.Context, apiKey string) (store.APIClient, error) {
+	client, err := a.Clients.GetByAPIKeyHash(ctx, HashAPIKey(apiKey))
+	if err != nil || !client.Active {
+		return store.APIClient{}, errors.New("invalid api key")
+	}
+
+	return client, nil
+}
+
+func (a *Authenticator) verifySignature(r *http.Request) (store.APIClient, error) {
+	clientID := r.Header.Get(clientIDHeader)
+	timestamp := r.Header.Get(timestampHeader)
+	nonce := r.Header.Get(nonceHeader)
+	signature := r.Header.Get(signatureHeader)
+	if clientID == "" || timestamp == "" || nonce == "" {
+		return store.APIClient{}, errors.New("missing signature headers")
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return store.APIClient{}, errors.New("invalid timestamp")
+	}
+
+	skew := a.now().Sub(time.Unix(unix, 0))
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > a.maxClockSkew() {
+		return store.APIClient{}, errors.New("stale signature")
+	}
+
+	client, err := a.Clients.GetByClientID(r.Context(), clientID)
+	if err != nil || !client.Active || client.HMACSecret == "" {
+		return store.APIClient{}, errors.New("invalid signature")
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return store.APIClient{}, errors.New("failed to read request body")
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	expected := SignRequest(client.HMACSecret, r.Method, r.URL.Path, r.URL.RawQuery, timestamp, nonce, r.Header.Get(userIDHeader), body)
+	provided, err := hex.DecodeString(signature)
+	if err != nil || !hmac.Equal(provided, expected) {
+		return store.APIClient{}, errors.New("invalid signature")
+	}
+
+	// The nonce is only recorded once the signature is known to be genuine so
+	// that forged requests cannot burn nonces for a real client.
+	if err := a.Clients.UseNonce(r.Context(), client.ClientID, nonce); err != nil {
+		if errors.Is(err, store.ErrNonceAlreadyUsed) {
+			return store.APIClient{}, errors.New("replayed request")
+		}
+		return store.APIClient{}, errors.New("failed to verify nonce")
+	}
+
+	return client, nil
+}
+
+// PurgeNonces removes nonces that are old enough that a request carrying them
+// would already be rejected as stale.
+func (a *Authenticator) PurgeNonces(ctx context.Context) error {
+	if a.Clients == nil {
+		return nil
+	}
+
+	return a.Clients.PurgeNonces(ctx, a.now().Add(-2*a.maxClockSkew()))
+}
+
+func (a *Authenticator) now() time.Time {
+	if a.Now == nil {
+		return time.Now()
+	}
+	return a.Now()
+}
+
+func (a *Authenticator) maxClockSkew() time.Duration {
+	if a.MaxClockSkew <= 0 {
+		return defaultMaxClockSkew
+	}
+	return a.MaxClockSkew
+}
+
+// SignRequest computes the HMAC-SHA256 signature a client must send in
+// X-Signature (hex encoded) over:
+//
+//	METHOD \n PATH \n QUERY \n TIMESTAMP \n NONCE \n X-User-Id \n hex(sha256(body))
+//
+// QUERY is the raw query string without "?" and, like X-User-Id, is empty
+// when absent. Both are signed so a captured request cannot be redirected to
+// other parameters or another user.
+func SignRequest(secret, method, path, rawQuery, timestamp, nonce, userID string, body []byte) []byte {
+	digest := sha256.Sum256(body)
+	canonical := strings.Join([]string{method, path, rawQuery, timestamp, nonce, userID, hex.EncodeToString(digest[:])}, "\n")
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(canonical))
+	return mac.Sum(nil)
+}
+
+// HashAPIKey returns the value stored in api_clients.api_key_hash for a key.
+func HashAPIKey(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:])
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	authorization := r.Header.Get("Authorization")
+	if len(authorization) < 7 || !strings.EqualFold(authorization[:7], "Bearer ") {
+		return "", false
+	}
+
+	token := strings.TrimSpace(authorization[7:])
+	return token, token != ""
+}
+
+// accountAllowed reports whether the caller may act on accountNumber. Only
+// users authenticated with a token carry an account list; channel callers are
+// trusted to have authorised their own users.
+func accountAllowed(r *http.Request, accountNumber string) bool {
+	accounts, ok := r.Context().Value(accountKey).([]string)
+	if !ok {
+		return true
+	}
+
+	for _, account := range accounts {
+		if account == accountNumber {
+			return true
+		}
+	}
+
+	return false
+}
+
+func requestUserID(r *http.Request, fallback string) string {
+	if userID, _ := r.Context().Value(userKey).(string); userID != "" {
+		return userID
+	}
+
+	return fallback
+}
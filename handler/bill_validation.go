@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/leopardquick/zssf/currency"
+	"github.com/leopardquick/zssf/model"
+	"github.com/leopardquick/zssf/store"
+)
+
+// billExpireLayouts are the date formats the gateway has been seen to use for
+// billExpireDate. Values without a zone are in the service's local time.
+var billExpireLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// validatePaymentAgainstBill checks a payment request against the enquiry
+// result cached for its vdResponseId.
+func validatePaymentAgainstBill(payment model.PaymentRequestApi, bill store.Bill, now time.Time) error {
+	if payment.ControlNo != bill.ControlNo {
+		return errors.New("control number does not match bill")
+	}
+
+	expiresAt, hasExpiry, err := parseBillExpireDate(bill.BillExpireDate)
+	if err != nil {
+		return err
+	}
+	if hasExpiry && now.After(expiresAt) {
+		return errors.New("bill has expired")
+	}
+
+	if bill.Currency != "" && !sameCurrency(payment.Currency, bill.Currency) {
+		return errors.New("currency does not match bill")
+	}
+
+	if payment.CreditAccount != "" && payment.CreditAccount != bill.CreditAccount {
+		return errors.New("credit account does not match bill")
+	}
+
+	amount := payment.Amount.WithCurrency(payment.Currency)
+
+	if c, ok := currency.Lookup(payment.Currency); ok && !c.Fits(amount) {
+		return fmt.Errorf("amount has more decimal places than %s allows", c.Code)
+	}
+
+	if bill.MinAmount.Sign() > 0 && amount.Cmp(bill.MinAmount) < 0 {
+		return errors.New("amount is below the bill minimum")
+	}
+
+	if requiresExactAmount(bill.PaymentOption) && amount.Cmp(bill.Amount) != 0 {
+		return errors.New("amount must equal the bill amount")
+	}
+
+	return nil
+}
+
+// sameCurrency compares currencies by ISO code, so a bill in "834" or "Tshs"
+// matches a payment in "TZS".
+func sameCurrency(a, b string) bool {
+	ca, okA := currency.Lookup(a)
+	cb, okB := currency.Lookup(b)
+	if okA && okB {
+		return ca.Code == cb.Code
+	}
+	return strings.EqualFold(strings.TrimSpace(a), strings.TrimSpace(b))
+}
+
+// requiresExactAmount reports whether the bill must be paid in one exact
+// amount. Gateways send either the GePG numeric code or its name.
+func requiresExactAmount(paymentOption string) bool {
+	switch strings.ToUpper(strings.TrimSpace(paymentOption)) {
+	case "1", "FULL", "3", "EXACT":
+		return true
+	default:
+		return false
+	}
+}
+
+// parseBillExpireDate returns false for a bill without an expiry date. A date
+// in none of billExpireLayouts is an error, so a bill whose expiry cannot be
+// checked is not paid.
+func parseBillExpireDate(value string) (time.Time, bool, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, false, nil
+	}
+
+	for _, layout := range billExpireLayouts {
+		if parsed, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			if layout == "2006-01-02" {
+				// a date-only expiry is valid for the whole day
+				parsed = parsed.Add(24*time.Hour - time.Nanosecond)
+			}
+			return parsed, true, nil
+		}
+	}
+
+	return time.Time{}, false, fmt.Errorf("bill expiry date %q is not recognised", value)
+}
@@ -1,61 +1,68 @@
 package handler
 
 import (
-	"bytes"
-	"crypto/sha256"
-	"database/sql"
-	"encoding/base64"
-	"encoding/hex"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"log"
-
+	"log/slog"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/leopardquick/zssf/billgateway"
+	"github.com/leopardquick/zssf/corebanking"
 	"github.com/leopardquick/zssf/helper"
+	"github.com/leopardquick/zssf/logging"
 	"github.com/leopardquick/zssf/model"
+	"github.com/leopardquick/zssf/redact"
 	"github.com/leopardquick/zssf/setup"
 	"github.com/leopardquick/zssf/store"
 )
 
 type ControlNumberHandler struct {
-	Client      *http.Client
+	Config      setup.Config
+	Secrets     setup.SecretProvider
 	RequestLogs store.RequestLogStore
 	Accounts    store.AccountStore
-	L           errorLogger
-	db          *sql.DB
+	Users       store.UserStore
+	Payments    store.PaymentStore
+	Idempotency store.IdempotencyStore
+	Bills       store.BillStore
+	CoreBanking corebanking.Client
+	Gateway     billgateway.Client
+	Statuses    *billgateway.Catalogue
+	Logger      *slog.Logger
+	Redaction   *redact.Policy
+	Activity    ActivityRecorder
+	Conflicts   ConflictRecorder
 }
 
-func NewControlNumberHandler(client *http.Client, requestLogs store.RequestLogStore, accounts store.AccountStore) *ControlNumberHandler {
-	if client == nil {
-		client = http.DefaultClient
+func NewControlNumberHandler(cfg setup.Config, secrets setup.SecretProvider, requestLogs store.RequestLogStore, accounts store.AccountStore, users store.UserStore, payments store.PaymentStore, idempotency store.IdempotencyStore, bills store.BillStore, coreBanking corebanking.Client, gateway billgateway.Client, logger *slog.Logger) *ControlNumberHandler {
+	if logger == nil {
+		logger = slog.Default()
 	}
 
 	return &ControlNumberHandler{
-		Client:      client,
+		Config:      cfg,
+		Secrets:     secrets,
 		RequestLogs: requestLogs,
 		Accounts:    accounts,
-		L:           stdErrorLogger{Logger: log.Default()},
+		Users:       users,
+		Payments:    payments,
+		Idempotency: idempotency,
+		Bills:       bills,
+		CoreBanking: coreBanking,
+		Gateway:     gateway,
+		Logger:      logger,
 	}
 }
 
-type errorLogger interface {
-	Error(args ...any)
-}
-
-type stdErrorLogger struct {
-	Logger *log.Logger
-}
-
-func (l stdErrorLogger) Error(args ...any) {
-	if l.Logger == nil {
-		log.Print(args...)
-		return
-	}
-	l.Logger.Print(args...)
+// responder returns the Handler whose response helpers write cn's request
+// logs.
+func (cn *ControlNumberHandler) responder() *Handler {
+	return &Handler{RequestLogs: cn.RequestLogs, Logger: cn.Logger, Redaction: cn.Redaction, Activity: cn.Activity}
 }
 
 type contextKey string
@@ -64,13 +71,11 @@ const (
 	userKey           contextKey = "user"
 	accountKey        contextKey = "account"
 	deviceUniqueIDKey contextKey = "deviceUniqueID"
+	channelKey        contextKey = "channel"
 )
 
 func (cn *ControlNumberHandler) Enquire(w http.ResponseWriter, r *http.Request) {
-	userID := r.Header.Get("X-User-Id")
-	if userID == "" {
-		userID = "unknown"
-	}
+	userID := requestUserID(r, "unknown")
 
 	requestBodyBytes, _ := io.ReadAll(r.Body)
 	requestBodyJSON := normalizeJSON(requestBodyBytes)
@@ -78,154 +83,84 @@ func (cn *ControlNumberHandler) Enquire(w http.ResponseWriter, r *http.Request)
 
 	if !json.Valid(requestBodyBytes) {
 		base := buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, helper.GenerateReferenceNumber(), userID)
-		respondWithLog(&Handler{RequestLogs: cn.RequestLogs}, w, r, base, http.StatusBadRequest, model.ErrorResponse{Error: "Invalid request payload"})
+		respondError(cn.responder(), w, r, base, newAPIError(http.StatusBadRequest, CodeInvalidRequest, "invalid request payload"))
 		return
 	}
 
 	var apiRequestEnquire model.ApiRequestEnquire
 
-	err := json.Unmarshal(requestBodyBytes, &apiRequestEnquire)
+	err := decodeRequest(requestBodyBytes, &apiRequestEnquire, cn.Config.StrictRequests)
 
 	if err != nil {
-		cn.L.Error("error decoding request body", err)
 		base := buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, helper.GenerateReferenceNumber(), userID)
-		respondWithLog(&Handler{RequestLogs: cn.RequestLogs}, w, r, base, http.StatusBadRequest, model.ErrorResponse{Error: "Invalid request payload"})
+		respondError(cn.responder(), w, r, base, asAPIError(err))
 		return
 	}
 
 	if apiRequestEnquire.RequestID == "" {
 		apiRequestEnquire.RequestID = "PBZAPP" + fmt.Sprintf("%d", time.Now().UnixNano()) + "CN-" + apiRequestEnquire.ControlNo
 	}
-	// check if request id is empty
 
-	if apiRequestEnquire.RequestID == "" {
-		cn.L.Error("request id is empty")
-		base := buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, helper.GenerateReferenceNumber(), userID)
-		respondWithLog(&Handler{RequestLogs: cn.RequestLogs}, w, r, base, http.StatusBadRequest, model.ErrorResponse{Error: "request id is empty"})
-		return
-	}
-
-	// check if control number is empty
-
-	if apiRequestEnquire.ControlNo == "" {
-		cn.L.Error("control number is empty")
+	if apiRequestEnquire.AccountNumber != "" && !accountAllowed(r, apiRequestEnquire.AccountNumber) {
 		base := buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, apiRequestEnquire.RequestID, userID)
-		respondWithLog(&Handler{RequestLogs: cn.RequestLogs}, w, r, base, http.StatusBadRequest, model.ErrorResponse{Error: "control number is empty"})
-		return
-
-	}
-
-	security_code, err := cn.GenerateSecurityCode(setup.CHANNEL_CODE, apiRequestEnquire.RequestID, setup.SECURITY_CODE)
-
-	if err != nil {
-		cn.L.Error("error generating security code", err)
-		base := buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, apiRequestEnquire.RequestID, userID)
-		respondWithLog(&Handler{RequestLogs: cn.RequestLogs}, w, r, base, http.StatusInternalServerError, model.ErrorResponse{Error: "Operation failed"})
+		respondError(cn.responder(), w, r, base, newAPIError(http.StatusForbidden, CodeAccountForbidden, "account does not belong to user"))
 		return
 	}
 
 	if cn.RequestLogs == nil {
 		base := buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, apiRequestEnquire.RequestID, userID)
-		respondWithLog(&Handler{RequestLogs: cn.RequestLogs}, w, r, base, http.StatusInternalServerError, model.ErrorResponse{Error: "request log store is not configured"})
-		return
-	}
-
-	requestLog, err := cn.RequestLogs.GetByRequestID(r.Context(), apiRequestEnquire.RequestID)
-	if err != nil {
-		if !errors.Is(err, store.ErrRequestLogNotFound) {
-			cn.L.Error("error checking request log", err)
-			base := buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, apiRequestEnquire.RequestID, userID)
-			respondWithLog(&Handler{RequestLogs: cn.RequestLogs}, w, r, base, http.StatusInternalServerError, model.ErrorResponse{Error: "failed to process request"})
-			return
-		}
-	} else if requestLog.RequestID != "" {
-		base := buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, apiRequestEnquire.RequestID, userID)
-		respondWithLog(&Handler{RequestLogs: cn.RequestLogs}, w, r, base, http.StatusConflict, model.ErrorResponse{Error: "request already used"})
-		return
-	}
-
-	client := &http.Client{
-		Timeout: 20 * time.Second,
-	}
-
-	defer client.CloseIdleConnections()
-
-	url := setup.BASE_URL + "bill/query"
-
-	enquireRequest := model.EnquireRequest{
-		ControlNo:    apiRequestEnquire.ControlNo,
-		RequestId:    apiRequestEnquire.RequestID,
-		ChannelCode:  setup.CHANNEL_CODE,
-		SecurityCode: security_code,
-	}
-
-	enquireRequestJson, err := json.Marshal(enquireRequest)
-
-	if err != nil {
-		cn.L.Error("error creating request", err)
-		base := buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, apiRequestEnquire.RequestID, userID)
-		respondWithLog(&Handler{RequestLogs: cn.RequestLogs}, w, r, base, http.StatusInternalServerError, model.ErrorResponse{Error: "Operation failed"})
-		return
-	}
-
-	request, err := http.NewRequest("POST", url, bytes.NewBuffer(enquireRequestJson))
-
-	if err != nil {
-		cn.L.Error("error creating request", err)
-		base := buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, apiRequestEnquire.RequestID, userID)
-		respondWithLog(&Handler{RequestLogs: cn.RequestLogs}, w, r, base, http.StatusInternalServerError, model.ErrorResponse{Error: "Operation failed"})
+		respondError(cn.responder(), w, r, base, internalError("request log store is not configured"))
 		return
 	}
 
-	request.Header.Set("Content-Type", "application/json")
-
-	response, err := client.Do(request)
-
-	if err != nil {
-		cn.L.Error("error sending request", err)
-		base := buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, apiRequestEnquire.RequestID, userID)
-		respondWithLog(&Handler{RequestLogs: cn.RequestLogs}, w, r, base, http.StatusInternalServerError, model.ErrorResponse{Error: "Operation failed"})
+	reserved, ok := reserveRequest(w, r, cn.Idempotency, cn.Activity, cn.Conflicts, apiRequestEnquire.RequestID, userID, requestBodyBytes)
+	if !ok {
 		return
 	}
+	defer reserved.finish(r.Context())
+	w = reserved
 
-	defer response.Body.Close()
-
-	var enquireResponse model.EnquireResponse
-
-	err = json.NewDecoder(response.Body).Decode(&enquireResponse)
+	enquireResponse, err := cn.Gateway.QueryBill(r.Context(), apiRequestEnquire.ControlNo, apiRequestEnquire.RequestID)
 
 	if err != nil {
-		cn.L.Error("error decoding response body", err)
+		apiErr := upstreamError(err, "bill enquiry failed")
+		var statusErr *billgateway.StatusError
+		if errors.As(err, &statusErr) {
+			apiErr = cn.gatewayRejection(r, statusErr)
+		} else {
+			cn.Logger.ErrorContext(r.Context(), "error querying bill", logging.KeyError, err)
+		}
 		base := buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, apiRequestEnquire.RequestID, userID)
-		respondWithLog(&Handler{RequestLogs: cn.RequestLogs}, w, r, base, http.StatusInternalServerError, model.ErrorResponse{Error: "Operation failed"})
+		respondError(cn.responder(), w, r, base, apiErr)
 		return
 	}
 
-	if enquireResponse.StatusId != "2000" {
-
-		if enquireResponse.StatusMessage == "" {
-			base := buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, apiRequestEnquire.RequestID, userID)
-			respondWithLog(&Handler{RequestLogs: cn.RequestLogs}, w, r, base, http.StatusInternalServerError, model.ErrorResponse{Error: "OPERATION FAILED"})
-			return
+	// cache the bill so the payment can be validated against it
+	if cn.Bills != nil {
+		err = cn.Bills.Save(r.Context(), store.Bill{
+			VDResponseID:   enquireResponse.Data.VDResponseID,
+			UserID:         userID,
+			ControlNo:      enquireResponse.Data.ControlNo,
+			Amount:         enquireResponse.Data.Amount,
+			MinAmount:      enquireResponse.Data.MinAmount,
+			Currency:       enquireResponse.Data.Currency,
+			PaymentOption:  enquireResponse.Data.PaymentOption,
+			PaymentPlan:    enquireResponse.Data.PaymentPlan,
+			CreditAccount:  enquireResponse.Data.CreditAccount,
+			BillExpireDate: enquireResponse.Data.BillExpireDate,
+		})
+		if err != nil {
+			cn.Logger.ErrorContext(r.Context(), "error caching bill enquiry", logging.KeyError, err)
 		}
-		base := buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, apiRequestEnquire.RequestID, userID)
-		respondWithLog(&Handler{RequestLogs: cn.RequestLogs}, w, r, base, http.StatusBadRequest, enquireResponse)
-		return
 	}
 
 	// insert into activity log
 	base := buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, apiRequestEnquire.RequestID, userID)
-	respondWithLog(&Handler{RequestLogs: cn.RequestLogs}, w, r, base, http.StatusOK, enquireResponse)
+	respondWithLog(cn.responder(), w, r, base, http.StatusOK, enquireResponse)
 }
 
 func (cn *ControlNumberHandler) PaymentPost(w http.ResponseWriter, r *http.Request) {
-	userID, _ := r.Context().Value(userKey).(string)
-	if userID == "" {
-		userID = r.Header.Get("X-User-Id")
-		if userID == "" {
-			userID = "known"
-		}
-	}
+	userID := requestUserID(r, "known")
 
 	requestBodyBytes, _ := io.ReadAll(r.Body)
 	requestBodyJSON := normalizeJSON(requestBodyBytes)
@@ -233,29 +168,18 @@ func (cn *ControlNumberHandler) PaymentPost(w http.ResponseWriter, r *http.Reque
 
 	if !json.Valid(requestBodyBytes) {
 		base := buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, helper.GenerateReferenceNumber(), userID)
-		respondWithLog(&Handler{RequestLogs: cn.RequestLogs}, w, r, base, http.StatusBadRequest, model.ErrorResponse{Error: "Invalid request payload"})
+		respondError(cn.responder(), w, r, base, newAPIError(http.StatusBadRequest, CodeInvalidRequest, "invalid request payload"))
 		return
 	}
 
 	// control number payment request
 	var apiPaymentRequest model.PaymentRequestApi
 
-	err := json.Unmarshal(requestBodyBytes, &apiPaymentRequest)
+	err := decodeRequest(requestBodyBytes, &apiPaymentRequest, cn.Config.StrictRequests)
 
 	if err != nil {
-		cn.L.Error("error decoding request body", err)
 		base := buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, helper.GenerateReferenceNumber(), userID)
-		respondWithLog(&Handler{RequestLogs: cn.RequestLogs}, w, r, base, http.StatusBadRequest, model.ErrorResponse{Error: "Invalid request payload"})
-		return
-	}
-
-	// decode request body
-
-	// check if control number is empty
-	if apiPaymentRequest.ControlNo == "" {
-		cn.L.Error("control number is empty")
-		base := buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, helper.GenerateReferenceNumber(), userID)
-		respondWithLog(&Handler{RequestLogs: cn.RequestLogs}, w, r, base, http.StatusBadRequest, model.ErrorResponse{Error: "control number is empty"})
+		respondError(cn.responder(), w, r, base, asAPIError(err))
 		return
 	}
 
@@ -266,12 +190,8 @@ func (cn *ControlNumberHandler) PaymentPost(w http.ResponseWriter, r *http.Reque
 	// set CLFlag to 1 for service to charge customer directly
 	apiPaymentRequest.CLFlag = "1"
 
-	// create a new db helper
-	helper := helper.NewDBHelper(nil, cn.db)
-
 	// insert into activity log
-
-	go helper.InsertActivityLog(
+	recordActivity(r.Context(), cn.Activity,
 		model.ActivityLog{
 			UserID:     userID,
 			LogMessage: "Payment post for control number " + apiPaymentRequest.ControlNo,
@@ -285,73 +205,140 @@ func (cn *ControlNumberHandler) PaymentPost(w http.ResponseWriter, r *http.Reque
 
 	// check if request id is empty
 
-	security_code, err := cn.GenerateSecurityCode(setup.CHANNEL_CODE, requestId, setup.SECURITY_CODE)
+	reserved, ok := reserveRequest(w, r, cn.Idempotency, cn.Activity, cn.Conflicts, requestId, userID, requestBodyBytes)
+	if !ok {
+		return
+	}
+	defer reserved.finish(r.Context())
+	w = reserved
+
+	if !accountAllowed(r, apiPaymentRequest.DebitAccount) {
+		base := buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, requestId, userID)
+		respondError(cn.responder(), w, r, base, newAPIError(http.StatusForbidden, CodeAccountForbidden, "account does not belong to user"))
+		return
+	}
+
+	if cn.Accounts == nil {
+		base := buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, requestId, userID)
+		respondError(cn.responder(), w, r, base, internalError("account store is not configured"))
+		return
+	}
 
+	exists, err := cn.Accounts.ExistsByAccountNumber(r.Context(), apiPaymentRequest.DebitAccount)
 	if err != nil {
-		cn.L.Error("error generating security code", err)
-		go helper.InsertActivityLog(
+		cn.Logger.ErrorContext(r.Context(), "error checking debit account", logging.KeyError, err)
+		base := buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, requestId, userID)
+		respondError(cn.responder(), w, r, base, internalError("failed to process request"))
+		return
+	}
+
+	if !exists {
+		base := buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, requestId, userID)
+		respondError(cn.responder(), w, r, base, newAPIError(http.StatusNotFound, CodeAccountNotFound, "account not listed in our records"))
+		return
+	}
+
+	canDebit, err := cn.Accounts.CanDebit(r.Context(), apiPaymentRequest.DebitAccount, userID)
+	if err != nil {
+		cn.Logger.ErrorContext(r.Context(), "error checking debit account ownership", logging.KeyError, err)
+		base := buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, requestId, userID)
+		respondError(cn.responder(), w, r, base, internalError("failed to process request"))
+		return
+	}
+
+	if !canDebit {
+		base := buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, requestId, userID)
+		respondError(cn.responder(), w, r, base, newAPIError(http.StatusForbidden, CodeAccountForbidden, "account does not belong to user"))
+		return
+	}
+
+	// the PIN is checked before core banking is asked about the account, so a
+	// caller without it learns nothing about the balance or restrictions
+	if err := cn.verifyPin(r.Context(), userID, apiPaymentRequest.Pin); err != nil {
+		recordActivity(r.Context(), cn.Activity,
 			model.ActivityLog{
 				UserID:     userID,
-				LogMessage: "Error generating security code-" + err.Error(),
+				LogMessage: "Payment pin verification failed-" + err.Error(),
 			},
 		)
 		base := buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, requestId, userID)
-		respondWithLog(&Handler{RequestLogs: cn.RequestLogs}, w, r, base, http.StatusInternalServerError, model.ErrorResponse{Error: "error generating security code"})
+		respondError(cn.responder(), w, r, base, asAPIError(err))
 		return
 	}
 
-	requestLog, err := cn.RequestLogs.GetByRequestID(r.Context(), requestId)
-	if err != nil {
-		if !errors.Is(err, store.ErrRequestLogNotFound) {
-			cn.L.Error("error checking request log", err)
-			base := buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, requestId, userID)
-			respondWithLog(&Handler{RequestLogs: cn.RequestLogs}, w, r, base, http.StatusInternalServerError, model.ErrorResponse{Error: "failed to process request"})
-			return
-		}
-	} else if requestLog.RequestID != "" {
+	if cn.Bills == nil {
 		base := buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, requestId, userID)
-		respondWithLog(&Handler{RequestLogs: cn.RequestLogs}, w, r, base, http.StatusConflict, model.ErrorResponse{Error: "request already used"})
+		respondError(cn.responder(), w, r, base, internalError("bill store is not configured"))
 		return
 	}
 
-	if apiPaymentRequest.DebitAccount == "" {
+	bill, err := cn.Bills.GetByVDResponseID(r.Context(), apiPaymentRequest.VDResponseID)
+	// another user's or an expired enquiry is reported as missing, so
+	// vdResponseIds cannot be probed
+	if err == nil && (bill.UserID != userID || bill.CreatedAt.Before(time.Now().Add(-cn.billEnquiryTTL()))) {
+		err = store.ErrBillNotFound
+	}
+	if err != nil {
+		apiErr := internalError("failed to process request")
+		if errors.Is(err, store.ErrBillNotFound) {
+			apiErr = newAPIError(http.StatusUnprocessableEntity, CodeBillNotFound, "bill not found, enquire the control number first")
+		} else {
+			cn.Logger.ErrorContext(r.Context(), "error loading bill", logging.KeyError, err)
+		}
 		base := buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, requestId, userID)
-		respondWithLog(&Handler{RequestLogs: cn.RequestLogs}, w, r, base, http.StatusBadRequest, model.ErrorResponse{Error: "debit account is required"})
+		respondError(cn.responder(), w, r, base, apiErr)
 		return
 	}
 
-	if cn.Accounts == nil {
+	if err := validatePaymentAgainstBill(apiPaymentRequest, bill, time.Now()); err != nil {
 		base := buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, requestId, userID)
-		respondWithLog(&Handler{RequestLogs: cn.RequestLogs}, w, r, base, http.StatusInternalServerError, model.ErrorResponse{Error: "account store is not configured"})
+		respondError(cn.responder(), w, r, base, newAPIError(http.StatusUnprocessableEntity, CodeBillMismatch, err.Error()))
 		return
 	}
 
-	exists, err := cn.Accounts.ExistsByAccountNumber(r.Context(), apiPaymentRequest.DebitAccount)
+	// the gateway credits the account on the bill, not whatever the client sent
+	apiPaymentRequest.CreditAccount = bill.CreditAccount
+
+	amount := apiPaymentRequest.Amount.WithCurrency(apiPaymentRequest.Currency)
+
+	debitAccount, err := cn.CoreBanking.VerifyAccount(r.Context(), apiPaymentRequest.DebitAccount)
 	if err != nil {
-		cn.L.Error("error checking debit account", err)
+		cn.Logger.ErrorContext(r.Context(), "error verifying debit account", logging.KeyError, err)
+		apiErr := upstreamError(err, "failed to verify debit account")
+		var cbErr *corebanking.Error
+		switch {
+		case errors.Is(err, corebanking.ErrAccountNotFound):
+			apiErr = newAPIError(http.StatusUnprocessableEntity, CodeAccountNotFound, "debit account not found in core banking")
+		case errors.As(err, &cbErr):
+			apiErr.Message = cbErr.Message
+		case errors.Is(err, corebanking.ErrInvalidAmount):
+			apiErr = internalError("failed to process request")
+		}
 		base := buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, requestId, userID)
-		respondWithLog(&Handler{RequestLogs: cn.RequestLogs}, w, r, base, http.StatusInternalServerError, model.ErrorResponse{Error: "failed to process request"})
+		respondError(cn.responder(), w, r, base, apiErr)
 		return
 	}
 
-	if !exists {
+	if err := debitAccount.CheckDebit(amount); err != nil {
+		code := CodeAccountRestricted
+		if errors.Is(err, corebanking.ErrInsufficientFunds) {
+			code = CodeInsufficientFunds
+		}
 		base := buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, requestId, userID)
-		respondWithLog(&Handler{RequestLogs: cn.RequestLogs}, w, r, base, http.StatusNotFound, model.ErrorResponse{Error: "account not listed in our records"})
+		respondError(cn.responder(), w, r, base, newAPIError(http.StatusUnprocessableEntity, code, err.Error()))
 		return
 	}
 
 	payment := model.PaymentRequest{
 		ControlNo:      apiPaymentRequest.ControlNo,
 		RequestID:      requestId,
-		ChannelCode:    setup.CHANNEL_CODE,
-		SecurityCode:   security_code,
 		VDResponseID:   apiPaymentRequest.VDResponseID,
 		PayerName:      apiPaymentRequest.PayerName,
 		MobileNo:       apiPaymentRequest.MobileNo,
 		Email:          apiPaymentRequest.Email,
 		DebitAccount:   apiPaymentRequest.DebitAccount,
 		CreditAccount:  apiPaymentRequest.CreditAccount,
-		Amount:         apiPaymentRequest.Amount,
+		Amount:         amount,
 		Currency:       apiPaymentRequest.Currency,
 		PaymentMethod:  apiPaymentRequest.PaymentMethod,
 		PSPReferenceID: apiPaymentRequest.PSPReferenceID,
@@ -359,124 +346,195 @@ func (cn *ControlNumberHandler) PaymentPost(w http.ResponseWriter, r *http.Reque
 		CLFlag:         apiPaymentRequest.CLFlag,
 	}
 
-	// check if payer name is empty
-
-	if payment.PayerName == "" {
-		payment.PayerName = "Not Provided"
-	}
-
-	// check if mobile number is empty
-
-	if payment.MobileNo == "" {
-		payment.MobileNo = "Not Provided"
-	}
-
-	client := &http.Client{
-		Timeout: 40 * time.Second,
+	if cn.Payments == nil {
+		base := buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, requestId, userID)
+		respondError(cn.responder(), w, r, base, internalError("payment store is not configured"))
+		return
 	}
 
-	defer client.CloseIdleConnections()
-
-	url := setup.BASE_URL + "payment/post"
-
-	paymentRequestJson, err := json.Marshal(payment)
-
+	// record the payment before it leaves the service so its outcome can
+	// always be traced, even if the process dies mid-call
+	err = cn.Payments.Create(r.Context(), store.Payment{
+		RequestID:     requestId,
+		UserID:        userID,
+		ControlNo:     payment.ControlNo,
+		VDResponseID:  payment.VDResponseID,
+		DebitAccount:  payment.DebitAccount,
+		CreditAccount: payment.CreditAccount,
+		Amount:        payment.Amount,
+		Currency:      payment.Currency,
+		State:         store.PaymentStatePending,
+	})
 	if err != nil {
-
-		go helper.InsertActivityLog(
-			model.ActivityLog{
-				UserID:     userID,
-				LogMessage: "Error creating request-" + err.Error(),
-			},
-		)
+		apiErr := internalError("failed to process request")
+		if errors.Is(err, store.ErrPaymentAlreadyExists) {
+			apiErr = newAPIError(http.StatusConflict, CodeRequestReplayed, "request already used")
+		} else {
+			cn.Logger.ErrorContext(r.Context(), "error recording payment", logging.KeyError, err)
+		}
 		base := buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, requestId, userID)
-		respondWithLog(&Handler{RequestLogs: cn.RequestLogs}, w, r, base, http.StatusInternalServerError, model.ErrorResponse{Error: "failed"})
+		respondError(cn.responder(), w, r, base, apiErr)
 		return
 	}
 
-	request, err := http.NewRequest("POST", url, bytes.NewBuffer(paymentRequestJson))
-
-	if err != nil {
+	if err := cn.Payments.Transition(r.Context(), requestId, store.PaymentStatePending, store.PaymentStateSubmitted, store.PaymentUpdate{}); err != nil {
+		cn.Logger.ErrorContext(r.Context(), "error marking payment submitted", logging.KeyError, err)
+		cn.transitionPayment(r.Context(), requestId, store.PaymentStatePending, store.PaymentStateFailed, store.PaymentUpdate{GatewayStatusMessage: "not submitted"})
 		base := buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, requestId, userID)
-		respondWithLog(&Handler{RequestLogs: cn.RequestLogs}, w, r, base, http.StatusInternalServerError, model.ErrorResponse{Error: "failed "})
+		respondError(cn.responder(), w, r, base, internalError("failed to process request"))
 		return
 	}
 
-	request.Header.Set("Content-Type", "application/json")
+	paymentResponse, err := cn.Gateway.PostPayment(r.Context(), payment)
 
-	response, err := client.Do(request)
+	var statusErr *billgateway.StatusError
+	switch {
+	case err == nil:
+		cn.transitionPayment(r.Context(), requestId, store.PaymentStateSubmitted, store.PaymentStateSucceeded, store.PaymentUpdate{
+			GatewayStatusID:      paymentResponse.StatusId,
+			GatewayStatusMessage: paymentResponse.StatusMessage,
+			GatewayRefID:         paymentResponse.Data.GatewayRefId,
+			ReceiptNo:            paymentResponse.Data.ReceiptNo,
+		})
 
-	if err != nil {
+		base := buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, requestId, userID)
+		respondWithLog(cn.responder(), w, r, base, http.StatusOK, paymentResponse)
+
+	case errors.As(err, &statusErr) && cn.Statuses.PaymentFailed(statusErr.StatusID):
+		cn.transitionPayment(r.Context(), requestId, store.PaymentStateSubmitted, store.PaymentStateFailed, store.PaymentUpdate{
+			GatewayStatusID:      statusErr.StatusID,
+			GatewayStatusMessage: statusErr.StatusMessage,
+		})
 
-		// insert into request logs
 		base := buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, requestId, userID)
-		respondWithLog(&Handler{RequestLogs: cn.RequestLogs}, w, r, base, http.StatusInternalServerError, model.ErrorResponse{Error: "failed"})
+		respondError(cn.responder(), w, r, base, cn.gatewayRejection(r, statusErr))
+
+	case errors.As(err, &statusErr):
+		// a statusId the catalogue does not mark payment_failed, such as a
+		// transient error, does not prove the debit was not made; the
+		// reconciler settles it
+		cn.Logger.WarnContext(r.Context(), "payment rejected with unconfirmed status", "payment_request_id", requestId, logging.KeyError, err)
+		cn.transitionPayment(r.Context(), requestId, store.PaymentStateSubmitted, store.PaymentStateUnknown, store.PaymentUpdate{
+			GatewayStatusID:      statusErr.StatusID,
+			GatewayStatusMessage: statusErr.StatusMessage,
+		})
+		cn.respondPaymentUnknown(w, r, requestBodyJSON, requestHeadersJSON, requestId, userID, apiPaymentRequest.ControlNo)
+
+	case errors.Is(err, billgateway.ErrNotSent):
+		cn.Logger.ErrorContext(r.Context(), "error sending payment", logging.KeyError, err)
+		cn.transitionPayment(r.Context(), requestId, store.PaymentStateSubmitted, store.PaymentStateFailed, store.PaymentUpdate{GatewayStatusMessage: err.Error()})
 
-		return
+		base := buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, requestId, userID)
+		respondError(cn.responder(), w, r, base, upstreamError(err, "payment could not be sent, please try again"))
+
+	default:
+		cn.transitionPayment(r.Context(), requestId, store.PaymentStateSubmitted, store.PaymentStateUnknown, store.PaymentUpdate{GatewayStatusMessage: err.Error()})
+		cn.respondPaymentUnknown(w, r, requestBodyJSON, requestHeadersJSON, requestId, userID, apiPaymentRequest.ControlNo)
 	}
+}
 
-	defer response.Body.Close()
+// gatewayRejection turns a gateway statusId into the error sent to the
+// client, using the status catalogue and the Accept-Language header.
+func (cn *ControlNumberHandler) gatewayRejection(r *http.Request, statusErr *billgateway.StatusError) APIError {
+	info := cn.Statuses.Lookup(statusErr.StatusID, statusErr.StatusMessage)
+	return APIError{
+		Status:    info.HTTPStatus,
+		Code:      info.Code,
+		Message:   info.Message(requestLanguage(r)),
+		Retryable: info.Retryable,
+	}
+}
 
-	responseBody, err := io.ReadAll(response.Body)
+// requestLanguage picks Swahili when it is the client's first preference and
+// English otherwise.
+func requestLanguage(r *http.Request) string {
+	preferred, _, _ := strings.Cut(r.Header.Get("Accept-Language"), ",")
+	preferred, _, _ = strings.Cut(preferred, ";")
+	preferred = strings.ToLower(strings.TrimSpace(preferred))
+	if preferred == billgateway.LanguageSwahili || strings.HasPrefix(preferred, billgateway.LanguageSwahili+"-") {
+		return billgateway.LanguageSwahili
+	}
+	return billgateway.LanguageEnglish
+}
 
-	if err != nil {
-		base := buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, requestId, userID)
-		respondWithLog(&Handler{RequestLogs: cn.RequestLogs}, w, r, base, http.StatusInternalServerError, model.ErrorResponse{Error: "Operation failed"})
+// respondPaymentUnknown tells the client the payment was sent but its outcome
+// is not yet known. The app should poll GET /control-number/payment/{requestId}
+// until the reconciler resolves it.
+func (cn *ControlNumberHandler) respondPaymentUnknown(w http.ResponseWriter, r *http.Request, requestBodyJSON, requestHeadersJSON []byte, requestID, userID, controlNo string) {
+	base := buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, requestID, userID)
+	respondWithLog(cn.responder(), w, r, base, http.StatusAccepted, model.PaymentStatusResponse{
+		RequestID:     requestID,
+		ControlNo:     controlNo,
+		State:         store.PaymentStateUnknown,
+		StatusMessage: "payment submitted, outcome is being confirmed",
+	})
+}
 
-		return
+// transitionPayment records a payment state change once the gateway call has
+// been made. It is detached from the request context so a client hanging up
+// does not leave the ledger behind the gateway; failures are only logged
+// because the customer-facing outcome has already been decided.
+func (cn *ControlNumberHandler) transitionPayment(ctx context.Context, requestID, from, to string, update store.PaymentUpdate) {
+	if err := cn.Payments.Transition(context.WithoutCancel(ctx), requestID, from, to, update); err != nil {
+		cn.Logger.ErrorContext(ctx, "error recording payment state", "payment_request_id", requestID, "from", from, "to", to, logging.KeyError, err)
+	}
+}
+
+// verifyPin checks the user's transaction PIN, locking the user once
+// PinMaxAttempts consecutive failures are reached. Failures are returned as
+// an APIError to respond with.
+func (cn *ControlNumberHandler) verifyPin(ctx context.Context, userID, pin string) error {
+	if cn.Users == nil {
+		return internalError("user store is not configured")
 	}
 
-	var paymentResponse model.ControlNumberPaymentResponse
+	user, err := cn.Users.GetByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, store.ErrUserNotFound) {
+			return newAPIError(http.StatusForbidden, CodeUnauthorized, "user not found")
+		}
+		cn.Logger.ErrorContext(ctx, "error loading user", logging.KeyError, err)
+		return internalError("failed to process request")
+	}
 
-	errs := json.Unmarshal(responseBody, &paymentResponse)
+	if user.Status == store.UserStatusLocked {
+		return newAPIError(http.StatusForbidden, CodeUserLocked, "user is locked")
+	}
 
-	if errs != nil {
-		base := buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, requestId, userID)
-		respondWithLog(&Handler{RequestLogs: cn.RequestLogs}, w, r, base, http.StatusInternalServerError, model.ErrorResponse{Error: "Operation failed"})
-		return
+	if user.PinHash == "" {
+		return newAPIError(http.StatusForbidden, CodeInvalidPin, "pin is not set")
 	}
 
-	// insert into request logs
+	if err := helper.DecryptPassword(pin, user.PinHash); err != nil {
+		if !errors.Is(err, helper.ErrPinMismatch) {
+			cn.Logger.ErrorContext(ctx, "error verifying pin", logging.KeyError, err)
+			return internalError("failed to process request")
+		}
 
-	if paymentResponse.StatusId != "2000" {
+		attempts, err := cn.Users.IncrementFailedPinAttempts(ctx, userID)
+		if err != nil {
+			cn.Logger.ErrorContext(ctx, "error recording failed pin attempt", logging.KeyError, err)
+			return internalError("failed to process request")
+		}
 
-		if paymentResponse.StatusMessage == "" {
-			base := buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, requestId, userID)
-			respondWithLog(&Handler{RequestLogs: cn.RequestLogs}, w, r, base, http.StatusInternalServerError, model.ErrorResponse{Error: "OPERATION FAILED"})
-			return
+		if attempts >= cn.Config.PinMaxAttempts {
+			if err := cn.Users.UpdateStatus(ctx, userID, store.UserStatusLocked); err != nil {
+				cn.Logger.ErrorContext(ctx, "error locking user", logging.KeyError, err)
+				return internalError("failed to process request")
+			}
+			return newAPIError(http.StatusForbidden, CodeUserLocked, "user is locked")
 		}
-		base := buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, requestId, userID)
-		respondWithLog(&Handler{RequestLogs: cn.RequestLogs}, w, r, base, http.StatusBadRequest, model.ErrorResponse{Error: paymentResponse.StatusMessage})
-		return
+
+		return newAPIError(http.StatusUnauthorized, CodeInvalidPin, fmt.Sprintf("invalid pin, %d attempts remaining", cn.Config.PinMaxAttempts-attempts))
 	}
-	base := buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, requestId, userID)
-	respondWithLog(&Handler{RequestLogs: cn.RequestLogs}, w, r, base, http.StatusOK, paymentResponse)
 
-}
+	if user.FailedPinAttempts > 0 {
+		if err := cn.Users.ResetFailedPinAttempts(ctx, userID); err != nil {
+			cn.Logger.ErrorContext(ctx, "error resetting pin attempts", logging.KeyError, err)
+		}
+	}
 
-func (cn *ControlNumberHandler) GenerateSecurityCode(channelCode, requestID, channelPassword string) (string, error) {
-	// Concatenate ChannelCode, RequestID, and Base64(ChannelPassword)
-	inputString := channelCode + requestID + base64.StdEncoding.EncodeToString([]byte(channelPassword))
-
-	// Calculate SHA256 hash
-	hasher := sha256.New()
-	hasher.Write([]byte(inputString))
-	hash := hasher.Sum(nil)
-
-	// Encode the hash in Base64
-	//hashBase64 := base64.StdEncoding.EncodeToString(hash)
-	hashBase64String := base64.StdEncoding.EncodeToString([]byte(hex.EncodeToString(hash)))
-
-	// fmt.Printf("channelcode: %v \n", channelCode)
-	// fmt.Printf("requestid: %v  \n", requestID)
-	// fmt.Printf("channelpassword: %v \n", channelPassword)
-	// fmt.Printf("hashedpassword: %v \n", base64.StdEncoding.EncodeToString([]byte(channelPassword)))
-	// fmt.Printf("beforesha256: %v \n", inputString)
-	// fmt.Printf("sha256: %v \n", hex.EncodeToString(hash))
-	// fmt.Printf("sha256base64: %v \n", hashBase64)
-	// fmt.Printf("sha256base64string: %v \n", hashBase64String)
-
-	return hashBase64String, nil
+	return nil
 }
 
 func ResponseWithError(w http.ResponseWriter, code int, message string) {
@@ -484,9 +542,19 @@ func ResponseWithError(w http.ResponseWriter, code int, message string) {
 }
 
 func ResponseWithJSON(w http.ResponseWriter, code int, payload interface{}) {
-	response, _ := json.Marshal(wrapResponse(code, payload))
+	writeEnvelope(w, code, wrapResponse(code, payload))
+}
+
+func (cn *ControlNumberHandler) billEnquiryTTL() time.Duration {
+	return time.Duration(cn.Config.BillEnquiryTTLSeconds) * time.Second
+}
+
+// PurgeBills removes cached bill enquiries too old to be paid against.
+func (cn *ControlNumberHandler) PurgeBills(ctx context.Context) error {
+	if cn.Bills == nil {
+		return nil
+	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(code)
-	_, _ = w.Write(response)
+	_, err := cn.Bills.DeleteCreatedBefore(ctx, time.Now().Add(-cn.billEnquiryTTL()))
+	return err
 }
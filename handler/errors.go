@@ -0,0 +1,120 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/leopardquick/zssf/billgateway"
+	"github.com/leopardquick/zssf/corebanking"
+	"github.com/leopardquick/zssf/outbound"
+	"github.com/leopardquick/zssf/store"
+)
+
+// Error codes sent in the "code" field of every error response. They are part
+// of the API: the mobile app branches on them, so never rename one.
+const (
+	CodeInvalidRequest      = "INVALID_REQUEST"
+	CodeValidationFailed    = "VALIDATION_FAILED"
+	CodeUnauthorized        = "UNAUTHORIZED"
+	CodeAccountForbidden    = "ACCOUNT_FORBIDDEN"
+	CodeAccountNotFound     = "ACCOUNT_NOT_FOUND"
+	CodeAccountRestricted   = "ACCOUNT_RESTRICTED"
+	CodeInsufficientFunds   = "INSUFFICIENT_FUNDS"
+	CodeBillNotFound        = "BILL_NOT_FOUND"
+	CodeBillMismatch        = "BILL_MISMATCH"
+	CodeInvalidPin          = "INVALID_PIN"
+	CodeUserLocked          = "USER_LOCKED"
+	CodePaymentNotFound     = "PAYMENT_NOT_FOUND"
+	CodeRequestReplayed     = "REQUEST_REPLAYED"
+	CodeRequestInProgress   = "REQUEST_IN_PROGRESS"
+	CodeUpstreamUnavailable = "UPSTREAM_UNAVAILABLE"
+	CodeUpstreamTimeout     = "UPSTREAM_TIMEOUT"
+	CodeUpstreamError       = "UPSTREAM_ERROR"
+	CodeInternal            = "INTERNAL_ERROR"
+)
+
+// APIError is the body of every error response. respondWithLog and
+// wrapResponse render it into the response envelope together with the
+// request ID.
+type APIError struct {
+	Status    int
+	Code      string
+	Message   string
+	Details   []FieldError
+	Retryable bool
+}
+
+func newAPIError(status int, code, message string) APIError {
+	return APIError{Status: status, Code: code, Message: message}
+}
+
+func internalError(message string) APIError {
+	return newAPIError(http.StatusInternalServerError, CodeInternal, message)
+}
+
+func (e APIError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// asAPIError returns the APIError in err's chain, or a generic internal error.
+func asAPIError(err error) APIError {
+	var apiErr APIError
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	return internalError("failed to process request")
+}
+
+// respondError logs and writes apiErr with its own status.
+func respondError(h *Handler, w http.ResponseWriter, r *http.Request, base store.RequestLog, apiErr APIError) {
+	respondWithLog(h, w, r, base, apiErr.Status, apiErr)
+}
+
+// writeError writes apiErr without a request log, for failures that happen
+// before a request is accepted. The request ID is chi's.
+func writeError(w http.ResponseWriter, r *http.Request, apiErr APIError) {
+	envelope := wrapResponse(apiErr.Status, apiErr)
+	envelope.RequestID = middleware.GetReqID(r.Context())
+	writeEnvelope(w, apiErr.Status, envelope)
+}
+
+// codeForStatus is the code used when only an HTTP status is known.
+func codeForStatus(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return CodeInvalidRequest
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusForbidden:
+		return CodeAccountForbidden
+	case http.StatusConflict:
+		return CodeRequestInProgress
+	case http.StatusUnprocessableEntity:
+		return CodeValidationFailed
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return CodeUpstreamUnavailable
+	case http.StatusGatewayTimeout:
+		return CodeUpstreamTimeout
+	default:
+		return CodeInternal
+	}
+}
+
+// upstreamError describes a failed call to core banking or the bill gateway
+// that did not produce an answer from the upstream.
+func upstreamError(err error, message string) APIError {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, outbound.ErrCircuitOpen), errors.Is(err, outbound.ErrConcurrencyLimit):
+		return APIError{Status: http.StatusServiceUnavailable, Code: CodeUpstreamUnavailable, Message: message, Retryable: true}
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return APIError{Status: http.StatusGatewayTimeout, Code: CodeUpstreamTimeout, Message: message, Retryable: true}
+	case errors.Is(err, billgateway.ErrNotSent), errors.Is(err, corebanking.ErrUnavailable):
+		return APIError{Status: http.StatusBadGateway, Code: CodeUpstreamUnavailable, Message: message, Retryable: true}
+	default:
+		return APIError{Status: http.StatusBadGateway, Code: CodeUpstreamError, Message: message}
+	}
+}
@@ -1,44 +1,82 @@
 package handler
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
-	"strconv"
-	"strings"
 
+	"github.com/leopardquick/zssf/corebanking"
+	"github.com/leopardquick/zssf/currency"
 	"github.com/leopardquick/zssf/helper"
+	"github.com/leopardquick/zssf/logging"
 	"github.com/leopardquick/zssf/model"
+	"github.com/leopardquick/zssf/redact"
 	"github.com/leopardquick/zssf/setup"
 	"github.com/leopardquick/zssf/store"
+	"github.com/leopardquick/zssf/tracing"
 )
 
 type Handler struct {
-	Client      *http.Client
+	Config      setup.Config
+	Secrets     setup.SecretProvider
 	RequestLogs store.RequestLogStore
 	Accounts    store.AccountStore
+	Idempotency store.IdempotencyStore
+	CoreBanking corebanking.Client
+	Logger      *slog.Logger
+	// Redaction is applied to request logs before they are stored; nil
+	// applies redact.DefaultPolicy.
+	Redaction *redact.Policy
+	// Activity records the audit trail; nil writes it to the log only.
+	Activity ActivityRecorder
+	// Conflicts counts idempotency conflicts; nil counts nothing.
+	Conflicts ConflictRecorder
 }
 
-func New(client *http.Client, requestLogs store.RequestLogStore, accounts store.AccountStore) *Handler {
-	if client == nil {
-		client = http.DefaultClient
+// ActivityRecorder queues audit trail entries without blocking the request.
+// *activity.Writer implements it.
+type ActivityRecorder interface {
+	Record(ctx context.Context, entry model.ActivityLog)
+}
+
+func recordActivity(ctx context.Context, recorder ActivityRecorder, entry model.ActivityLog) {
+	if recorder == nil {
+		helper.InsertActivityLog(entry)
+		return
+	}
+	recorder.Record(ctx, entry)
+}
+
+func New(cfg setup.Config, secrets setup.SecretProvider, requestLogs store.RequestLogStore, accounts store.AccountStore, idempotency store.IdempotencyStore, coreBanking corebanking.Client, logger *slog.Logger) *Handler {
+	if logger == nil {
+		logger = slog.Default()
 	}
 
 	return &Handler{
-		Client:      client,
+		Config:      cfg,
+		Secrets:     secrets,
 		RequestLogs: requestLogs,
 		Accounts:    accounts,
+		Idempotency: idempotency,
+		CoreBanking: coreBanking,
+		Logger:      logger,
 	}
 }
 
-func (h *Handler) AccountBalance(w http.ResponseWriter, r *http.Request) {
-	userID := r.Header.Get("X-User-Id")
-	if userID == "" {
-		userID = "unknown"
+// logger returns h.Logger, or the default logger for the partial Handlers
+// built to reuse the response helpers.
+func (h *Handler) logger() *slog.Logger {
+	if h == nil || h.Logger == nil {
+		return slog.Default()
 	}
+	return h.Logger
+}
+
+func (h *Handler) AccountBalance(w http.ResponseWriter, r *http.Request) {
+	userID := requestUserID(r, "unknown")
 
 	requestBodyBytes, _ := io.ReadAll(r.Body)
 	requestBodyJSON := normalizeJSON(requestBodyBytes)
@@ -51,206 +89,109 @@ func (h *Handler) AccountBalance(w http.ResponseWriter, r *http.Request) {
 		base.RequestMethod = requestMethod
 		base.RequestPath = requestPath
 		base.RequestQuery = requestQuery
-		respondWithLog(h, w, r, base, http.StatusBadRequest, model.ErrorResponse{Error: "invalid request payload"})
+		respondError(h, w, r, base, newAPIError(http.StatusBadRequest, CodeInvalidRequest, "invalid request payload"))
 		return
 	}
 
 	var accountBalanceRequest model.AccountBalanceRequest
-	if err := json.Unmarshal(requestBodyBytes, &accountBalanceRequest); err != nil {
+	if err := decodeRequest(requestBodyBytes, &accountBalanceRequest, h.Config.StrictRequests); err != nil {
 		base := buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, helper.GenerateReferenceNumber(), userID)
 		base.RequestMethod = requestMethod
 		base.RequestPath = requestPath
 		base.RequestQuery = requestQuery
-		respondWithLog(h, w, r, base, http.StatusBadRequest, model.ErrorResponse{Error: "invalid request payload"})
+		respondError(h, w, r, base, asAPIError(err))
 		return
 	}
 
-	if accountBalanceRequest.AccountNumber == "" {
-		respondWithLog(h, w, r, buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, accountBalanceRequest.RequestID, ""), http.StatusBadRequest, model.ErrorResponse{Error: "account number is required"})
+	if !accountAllowed(r, accountBalanceRequest.AccountNumber) {
+		respondError(h, w, r, buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, accountBalanceRequest.RequestID, userID), newAPIError(http.StatusForbidden, CodeAccountForbidden, "account does not belong to user"))
 		return
 	}
 
-	if accountBalanceRequest.RequestID == "" {
-		generatedID := helper.GenerateReferenceNumber()
-		respondWithLog(h, w, r, buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, generatedID, ""), http.StatusBadRequest, model.ErrorResponse{Error: "requestId is required"})
-		return
-	}
-
-	client := h.Client
-	if client == nil {
-		client = http.DefaultClient
-	}
-
 	if h.RequestLogs == nil {
-		respondWithLog(h, w, r, buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, accountBalanceRequest.RequestID, userID), http.StatusInternalServerError, model.ErrorResponse{Error: "request log store is not configured"})
+		respondError(h, w, r, buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, accountBalanceRequest.RequestID, userID), internalError("request log store is not configured"))
 		return
 	}
 
 	requestID := accountBalanceRequest.RequestID
-	requestLog, err := h.RequestLogs.GetByRequestID(r.Context(), requestID)
-	if err != nil {
-		if !errors.Is(err, store.ErrRequestLogNotFound) {
-			go helper.InsertActivityLog(model.ActivityLog{
-				UserID:     userID,
-				LogMessage: "Account balance request failed to read request log error : " + err.Error(),
-			},
-			)
-			respondWithLog(h, w, r, buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, requestID, userID), http.StatusInternalServerError, model.ErrorResponse{Error: "failed to process request"})
-			return
-		}
-	} else if requestLog.RequestID != "" {
-		respondWithLog(h, w, r, buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, requestID, userID), http.StatusConflict, model.ErrorResponse{Error: "request already used"})
+	reserved, ok := reserveRequest(w, r, h.Idempotency, h.Activity, h.Conflicts, requestID, userID, requestBodyBytes)
+	if !ok {
 		return
 	}
+	defer reserved.finish(r.Context())
+	w = reserved
 
 	if h.Accounts == nil {
-		respondWithLog(h, w, r, buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, requestID, userID), http.StatusInternalServerError, model.ErrorResponse{Error: "account store is not configured"})
+		respondError(h, w, r, buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, requestID, userID), internalError("account store is not configured"))
 		return
 	}
 
 	exists, err := h.Accounts.ExistsByAccountNumber(r.Context(), accountBalanceRequest.AccountNumber)
 	if err != nil {
-		go helper.InsertActivityLog(model.ActivityLog{
+		h.logger().ErrorContext(r.Context(), "error checking account", logging.KeyError, err)
+		recordActivity(r.Context(), h.Activity, model.ActivityLog{
 			UserID:     userID,
 			LogMessage: "Account balance request failed to check account number error : " + err.Error(),
 		})
-		respondWithLog(h, w, r, buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, requestID, userID), http.StatusInternalServerError, model.ErrorResponse{Error: "failed to process request"})
+		respondError(h, w, r, buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, requestID, userID), internalError("failed to process request"))
 		return
 	}
 
 	if !exists {
-		respondWithLog(h, w, r, buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, requestID, userID), http.StatusNotFound, model.ErrorResponse{Error: "account not listed in our records"})
+		respondError(h, w, r, buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, requestID, userID), newAPIError(http.StatusNotFound, CodeAccountNotFound, "account not listed in our records"))
 		return
 	}
 
-	var accountVerification model.AccountVerificationRequest
-	referenceNumber := helper.GenerateReferenceNumber()
-
-	accountVerification.AccountNumber = accountBalanceRequest.AccountNumber
-	accountVerification.ReferenceNumber = referenceNumber
-
-	accountVerificationBytes, err := json.Marshal(accountVerification)
-
+	owned, err := h.Accounts.IsOwnedBy(r.Context(), accountBalanceRequest.AccountNumber, userID)
 	if err != nil {
-		fmt.Println("Error marshalling account verification request", err)
-		// insert into activity log table in a go routine if their is error fmt.Println(err)
-		go helper.InsertActivityLog(model.ActivityLog{
+		h.logger().ErrorContext(r.Context(), "error checking account ownership", logging.KeyError, err)
+		recordActivity(r.Context(), h.Activity, model.ActivityLog{
 			UserID:     userID,
-			LogMessage: "Account balance request failed to marshal account verification request error : " + err.Error(),
-		},
-		)
-		respondWithLog(h, w, r, buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, requestID, userID), http.StatusInternalServerError, model.ErrorResponse{Error: "failed to get account balance"})
+			LogMessage: "Account balance request failed to check account ownership error : " + err.Error(),
+		})
+		respondError(h, w, r, buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, requestID, userID), internalError("failed to process request"))
 		return
 	}
 
-	req, err := http.NewRequest(http.MethodPost, setup.ACCOUNT_VERIFICATION_URL+"/service1/account-verification", bytes.NewBuffer(accountVerificationBytes))
-
-	if err != nil {
-		fmt.Println("Error creating account verification request", err)
-		// insert into activity log table in a go routine if their is error fmt.Println(err)
-		go helper.InsertActivityLog(model.ActivityLog{
-			UserID:     userID,
-			LogMessage: "Account balance request failed to create account verification reques error : " + err.Error(),
-		},
-		)
-		respondWithLog(h, w, r, buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, requestID, userID), http.StatusInternalServerError, model.ErrorResponse{Error: "failed to get account balance"})
+	if !owned {
+		respondError(h, w, r, buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, requestID, userID), newAPIError(http.StatusForbidden, CodeAccountForbidden, "account does not belong to user"))
 		return
 	}
 
-	req.Header = http.Header{
-		"Content-Type":  []string{"application/json"},
-		"x-request-id":  []string{referenceNumber},
-		"Authorization": []string{setup.ACCOUNT_VERIFICATION_KEY},
-	}
-
-	resp, err := client.Do(req)
-
+	account, err := h.CoreBanking.VerifyAccount(r.Context(), accountBalanceRequest.AccountNumber)
 	if err != nil {
-		fmt.Println("Error getting account balance", err)
-		// insert into activity log table in a go routine if their is error fmt.Println(err)
-		go helper.InsertActivityLog(model.ActivityLog{
+		h.logger().WarnContext(r.Context(), "error verifying account", "accountNumber", accountBalanceRequest.AccountNumber, logging.KeyError, err)
+		recordActivity(r.Context(), h.Activity, model.ActivityLog{
 			UserID:     userID,
-			LogMessage: "Account balance request failed to get account balance error : " + err.Error(),
+			LogMessage: "Account balance request failed to verify account error : " + err.Error(),
 		},
 		)
-		respondWithLog(h, w, r, buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, requestID, userID), http.StatusInternalServerError, model.ErrorResponse{Error: "request error service unvailable"})
-		return
-	}
-
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-
-		var errorResponse model.ErrorResponse
-
-		err = json.NewDecoder(resp.Body).Decode(&errorResponse)
 
-		if err != nil {
-			// insert into activity log table in a go routine if their is error fmt.Println(err)
-			go helper.InsertActivityLog(model.ActivityLog{
-				UserID:     userID,
-				LogMessage: "Account balance request failed to decode error response error : " + err.Error(),
-			},
-			)
-			respondWithLog(h, w, r, buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, requestID, userID), http.StatusInternalServerError, model.ErrorResponse{Error: "Error gettting account balance"})
-			return
+		apiErr := upstreamError(err, "failed to get account balance")
+		var cbErr *corebanking.Error
+		switch {
+		case errors.Is(err, corebanking.ErrAccountNotFound):
+			apiErr = newAPIError(http.StatusNotFound, CodeAccountNotFound, "account not found")
+		case errors.As(err, &cbErr):
+			apiErr.Message = cbErr.Message
+		case errors.Is(err, corebanking.ErrInvalidAmount):
+			apiErr = internalError("invalid account balance format")
 		}
-
-		// if status code is  bad request
-		go helper.InsertActivityLog(model.ActivityLog{
-			UserID:     userID,
-			LogMessage: "Account balance request failed- " + errorResponse.Error,
-		},
-		)
-
-		respondWithLog(h, w, r, buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, requestID, userID), http.StatusInternalServerError, model.ErrorResponse{Error: errorResponse.Error})
+		respondError(h, w, r, buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, requestID, userID), apiErr)
 		return
-
-	}
-
-	var accountVerificationRespond model.AccountVerificationRespond
-
-	err = json.NewDecoder(resp.Body).Decode(&accountVerificationRespond)
-
-	if err != nil {
-		// insert into activity log table in a go routine if their is error fmt.Println(err)
-		go helper.InsertActivityLog(model.ActivityLog{
-			UserID:     userID,
-			LogMessage: "Account balance request failed to decode account verification response error : " + err.Error(),
-		},
-		)
-		respondWithLog(h, w, r, buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, requestID, userID), http.StatusInternalServerError, model.ErrorResponse{Error: "Error verifying registration"})
-		return
-	}
-
-	currency := "$ "
-
-	if accountVerificationRespond.AccountCurrency == "1 TZS" {
-		currency = "TZS "
 	}
 
-	// requires adding "strconv" and "strings" to imports
-	raw := strings.TrimSpace(accountVerificationRespond.AccountBalance)
-	raw = strings.ReplaceAll(raw, ",", "")
-	raw = strings.TrimPrefix(raw, "$")
-	raw = strings.TrimPrefix(raw, "Tshs")
-	raw = strings.TrimSpace(raw)
-
-	balance, err := strconv.ParseFloat(raw, 64)
-	if err != nil {
-		go helper.InsertActivityLog(model.ActivityLog{
-			UserID:     userID,
-			LogMessage: "Account balance request failed to parse account balance error : " + err.Error(),
-		})
-		respondWithLog(h, w, r, buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, requestID, userID), http.StatusInternalServerError, model.ErrorResponse{Error: "invalid account balance format"})
-		return
+	accountCurrency, ok := currency.Lookup(account.Currency)
+	if !ok {
+		accountCurrency = currency.Currency{Code: account.Currency, MinorUnits: 2}
 	}
 
 	accountBalance := model.AccountBalanceResponse{
-		AccountBalance: balance,
-		Currency:       currency,
-		AccountNumber:  accountBalanceRequest.AccountNumber,
-		AccountName:    accountVerificationRespond.CustomerName,
+		AccountBalance:   account.Balance.Number(),
+		Currency:         accountCurrency.Code,
+		FormattedBalance: accountCurrency.Format(account.Balance),
+		AccountNumber:    accountBalanceRequest.AccountNumber,
+		AccountName:      account.CustomerName,
 	}
 
 	respondWithLog(h, w, r, buildRequestLogBase(r, requestBodyJSON, requestHeadersJSON, requestID, userID), http.StatusOK, accountBalance)
@@ -265,9 +206,7 @@ func (h *Handler) AccountBalance(w http.ResponseWriter, r *http.Request) {
 
 	// go helper.SendSMS(number, "Salio+lako+la+"+accountBalanceRequest.AccountNumber+"+ni+"+formatCurrency(accountBalance.AccountBalance)+".+"+timeNow+"+Tuma+Pesa+kwa+urahisi+na+PBZ+APP")
 
-	// insert into activity log table in a go routine if their is error fmt.Println(err)
-
-	go helper.InsertActivityLog(model.ActivityLog{
+	recordActivity(r.Context(), h.Activity, model.ActivityLog{
 		UserID:     userID,
 		LogMessage: "Account balance generated successfully",
 	},
@@ -289,20 +228,27 @@ func buildRequestLogBase(r *http.Request, requestBodyJSON []byte, requestHeaders
 		RequestBody:    requestBodyJSON,
 		RequestHeaders: requestHeadersJSON,
 		RequestReceipt: requestID,
+		TraceID:        tracing.TraceID(r.Context()),
 	}
 }
 
 func respondWithLog(h *Handler, w http.ResponseWriter, r *http.Request, base store.RequestLog, status int, payload any) {
-	responseBody := mustJSON(wrapResponse(status, payload))
+	envelope := wrapResponse(status, payload)
+	if envelope.Code != "" {
+		envelope.RequestID = base.RequestID
+	}
+
+	responseBody := mustJSON(envelope)
 	responseHeaders := mustJSON(headerToMap(http.Header{"Content-Type": []string{"application/json"}}))
 
 	base.ResponseStatusCode = status
 	base.ResponseBody = responseBody
 	base.ResponseHeaders = responseHeaders
 	if h != nil && h.RequestLogs != nil {
-		logErr := h.RequestLogs.Create(r.Context(), base)
+		logErr := h.RequestLogs.Create(r.Context(), redactRequestLog(h.Redaction, base))
 		if logErr != nil {
-			go helper.InsertActivityLog(model.ActivityLog{
+			h.logger().ErrorContext(r.Context(), "error writing request log", logging.KeyError, logErr)
+			recordActivity(r.Context(), h.Activity, model.ActivityLog{
 				UserID:     base.UserID,
 				LogMessage: "Account balance request failed to write request log error : " + logErr.Error(),
 			},
@@ -315,10 +261,16 @@ func respondWithLog(h *Handler, w http.ResponseWriter, r *http.Request, base sto
 	_, _ = w.Write(responseBody)
 }
 
+// responseEnvelope wraps every response. Error responses set Error to the
+// message and always carry a Code and the RequestID to quote to support.
 type responseEnvelope struct {
-	StatusCode int    `json:"statusCode"`
-	Data       any    `json:"data,omitempty"`
-	Error      string `json:"error,omitempty"`
+	StatusCode int          `json:"statusCode"`
+	Data       any          `json:"data,omitempty"`
+	Error      string       `json:"error,omitempty"`
+	Code       string       `json:"code,omitempty"`
+	Retryable  bool         `json:"retryable,omitempty"`
+	Details    []FieldError `json:"details,omitempty"`
+	RequestID  string       `json:"requestId,omitempty"`
 }
 
 func wrapResponse(status int, payload any) responseEnvelope {
@@ -340,18 +292,45 @@ func wrapResponse(status int, payload any) responseEnvelope {
 			v.StatusCode = status
 		}
 		return *v
+	case APIError:
+		return errorEnvelope(status, v)
+	case *APIError:
+		if v == nil {
+			return responseEnvelope{StatusCode: status}
+		}
+		return errorEnvelope(status, *v)
 	case model.ErrorResponse:
-		return responseEnvelope{StatusCode: status, Error: v.Error}
+		return errorEnvelope(status, newAPIError(status, codeForStatus(status), v.Error))
 	case *model.ErrorResponse:
 		if v == nil {
 			return responseEnvelope{StatusCode: status}
 		}
-		return responseEnvelope{StatusCode: status, Error: v.Error}
+		return errorEnvelope(status, newAPIError(status, codeForStatus(status), v.Error))
 	default:
 		return responseEnvelope{StatusCode: status, Data: payload}
 	}
 }
 
+func errorEnvelope(status int, apiErr APIError) responseEnvelope {
+	return responseEnvelope{
+		StatusCode: status,
+		Error:      apiErr.Message,
+		Code:       apiErr.Code,
+		Retryable:  apiErr.Retryable,
+		Details:    apiErr.Details,
+	}
+}
+
+// redactRequestLog applies policy to the headers and bodies of log. Only the
+// stored copy is redacted; the client still receives the full response.
+func redactRequestLog(policy *redact.Policy, log store.RequestLog) store.RequestLog {
+	log.RequestHeaders = policy.Header(log.RequestHeaders)
+	log.RequestBody = policy.RequestBody(log.RequestBody)
+	log.ResponseHeaders = policy.Header(log.ResponseHeaders)
+	log.ResponseBody = policy.ResponseBody(log.ResponseBody)
+	return log
+}
+
 func mustJSON(payload any) []byte {
 	if payload == nil {
 		return []byte("null")
@@ -359,7 +338,7 @@ func mustJSON(payload any) []byte {
 
 	data, err := json.Marshal(payload)
 	if err != nil {
-		fallback, _ := json.Marshal(model.ErrorResponse{Error: "internal server error"})
+		fallback, _ := json.Marshal(errorEnvelope(http.StatusInternalServerError, internalError("internal server error")))
 		return fallback
 	}
 
@@ -394,7 +373,11 @@ func (h *Handler) ResponseWithError(w http.ResponseWriter, status int, message s
 }
 
 func (h *Handler) ResponseWithJSON(w http.ResponseWriter, status int, payload any) {
-	response, err := json.Marshal(wrapResponse(status, payload))
+	writeEnvelope(w, status, wrapResponse(status, payload))
+}
+
+func writeEnvelope(w http.ResponseWriter, status int, envelope responseEnvelope) {
+	response, err := json.Marshal(envelope)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
@@ -0,0 +1,169 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/leopardquick/zssf/model"
+	"github.com/leopardquick/zssf/store"
+)
+
+const (
+	idempotencyKeyHeader = "Idempotency-Key"
+
+	// reservationTTL is how long an idempotency key may stay reserved without
+	// a response before a retry takes it over, e.g. after the process holding
+	// it crashed. It is well above the longest upstream timeout.
+	reservationTTL = 5 * time.Minute
+
+	// reasons passed to ConflictRecorder
+	conflictInProgress = "in_progress"
+	conflictKeyReused  = "key_reused"
+)
+
+// ConflictRecorder counts requests refused because their idempotency key was
+// already in use, by reason. *metrics.Metrics implements it.
+type ConflictRecorder interface {
+	IdempotencyConflict(r *http.Request, reason string)
+}
+
+func recordConflict(r *http.Request, recorder ConflictRecorder, reason string) {
+	if recorder != nil {
+		recorder.IdempotencyConflict(r, reason)
+	}
+}
+
+// reservation is a claimed idempotency key. It wraps the response writer and
+// keeps a copy of the response so finish can store it with the key.
+type reservation struct {
+	middleware.WrapResponseWriter
+	keys     store.IdempotencyStore
+	activity ActivityRecorder
+	key      string
+	userID   string
+	body     bytes.Buffer
+}
+
+// reserveRequest claims the request's idempotency key (the Idempotency-Key
+// header, or requestID when absent) before any upstream call is made. It
+// returns false when it has already written the response:
+//
+//   - the same key with a different body gets 422;
+//   - the same key and body whose first attempt has finished gets the stored
+//     response replayed verbatim;
+//   - the same key and body whose first attempt is still running gets 409.
+//
+// Otherwise the caller must write its response through the returned
+// reservation and call finish once it has.
+func reserveRequest(w http.ResponseWriter, r *http.Request, keys store.IdempotencyStore, activity ActivityRecorder, conflicts ConflictRecorder, requestID, userID string, body []byte) (*reservation, bool) {
+	if keys == nil {
+		writeError(w, r, internalError("idempotency store is not configured"))
+		return nil, false
+	}
+
+	key := r.Header.Get(idempotencyKeyHeader)
+	if key == "" {
+		key = requestID
+	}
+
+	digest := sha256.Sum256(append([]byte(r.Method+" "+r.URL.Path+"\n"), body...))
+	requestHash := hex.EncodeToString(digest[:])
+
+	existing, reserved, err := keys.Reserve(r.Context(), store.IdempotencyKey{
+		Key:         key,
+		RequestID:   requestID,
+		RequestPath: r.URL.Path,
+		RequestHash: requestHash,
+		UserID:      userID,
+	}, time.Now().Add(-reservationTTL))
+	if err != nil {
+		recordActivity(r.Context(), activity, model.ActivityLog{
+			UserID:     userID,
+			LogMessage: "Request failed to reserve idempotency key error : " + err.Error(),
+		})
+		writeError(w, r, internalError("failed to process request"))
+		return nil, false
+	}
+
+	if reserved {
+		res := &reservation{
+			WrapResponseWriter: middleware.NewWrapResponseWriter(w, r.ProtoMajor),
+			keys:               keys,
+			activity:           activity,
+			key:                key,
+			userID:             userID,
+		}
+		res.Tee(&res.body)
+		return res, true
+	}
+
+	if existing.RequestHash != requestHash || existing.UserID != userID {
+		recordConflict(r, conflicts, conflictKeyReused)
+		writeError(w, r, newAPIError(http.StatusUnprocessableEntity, CodeRequestReplayed, "idempotency key already used for a different request"))
+		return nil, false
+	}
+
+	if existing.ResponseStatus == 0 {
+		recordConflict(r, conflicts, conflictInProgress)
+		writeError(w, r, newAPIError(http.StatusConflict, CodeRequestInProgress, "request is already being processed"))
+		return nil, false
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(existing.ResponseStatus)
+	_, _ = w.Write(existing.ResponseBody)
+	return nil, false
+}
+
+// finish stores a final response written through res with its key so retries
+// get it replayed. When the response is not final, nothing was written (for
+// instance because the handler panicked) or the response could not be stored,
+// the key is released so a retry runs the request again instead of getting
+// 409 until the reservation goes stale.
+func (res *reservation) finish(ctx context.Context) {
+	// the client may already have gone; the key must still be settled
+	ctx = context.WithoutCancel(ctx)
+
+	if status := res.Status(); res.final(status) {
+		err := res.keys.Complete(ctx, res.key, status, res.body.Bytes())
+		if err == nil {
+			return
+		}
+		recordActivity(ctx, res.activity, model.ActivityLog{
+			UserID:     res.userID,
+			LogMessage: "Request failed to store idempotent response error : " + err.Error(),
+		})
+	}
+
+	if err := res.keys.Release(ctx, res.key); err != nil {
+		recordActivity(ctx, res.activity, model.ActivityLog{
+			UserID:     res.userID,
+			LogMessage: "Request failed to release idempotency key error : " + err.Error(),
+		})
+	}
+}
+
+// final reports whether a response with status settles the request: a
+// success, including 202 for a payment whose outcome is being confirmed, or a
+// 4xx rejection. 5xx and retryable errors invite the client to try again, so
+// replaying them would stop the retry from ever running.
+func (res *reservation) final(status int) bool {
+	if status == 0 || status >= http.StatusInternalServerError {
+		return false
+	}
+
+	var envelope struct {
+		Retryable bool `json:"retryable"`
+	}
+	if err := json.Unmarshal(res.body.Bytes(), &envelope); err != nil {
+		return true
+	}
+	return !envelope.Retryable
+}
@@ -0,0 +1,216 @@
+package handler
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrTokenExpired = errors.New("token expired")
+)
+
+// TokenClaims are the claims the mobile app token must carry.
+type TokenClaims struct {
+	Subject        string   `json:"sub"`
+	Issuer         string   `json:"iss"`
+	Audience       audience `json:"aud"`
+	ExpiresAt      int64    `json:"exp"`
+	NotBefore      int64    `json:"nbf"`
+	Accounts       []string `json:"accounts"`
+	DeviceUniqueID string   `json:"device_id"`
+}
+
+// audience accepts both the string and array forms allowed by RFC 7519.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return err
+	}
+	*a = multiple
+	return nil
+}
+
+func (a audience) contains(value string) bool {
+	for _, item := range a {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// JWTVerifier validates HS256 tokens against a shared secret and RS256 tokens
+// against RSA keys loaded from a JWKS file.
+type JWTVerifier struct {
+	HMACSecret []byte
+	RSAKeys    map[string]*rsa.PublicKey
+	Audience   string
+	Issuer     string
+	Leeway     time.Duration
+	Now        func() time.Time
+}
+
+func (v *JWTVerifier) Verify(token string) (TokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return TokenClaims{}, ErrInvalidToken
+	}
+
+	headerBytes, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return TokenClaims{}, ErrInvalidToken
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := json.Unmarshal(headerBytes, &header); err != nil {
+		return TokenClaims{}, ErrInvalidToken
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return TokenClaims{}, ErrInvalidToken
+	}
+
+	signingInput := []byte(parts[0] + "." + parts[1])
+	if err := v.verifySignature(header.Alg, header.Kid, signingInput, signature); err != nil {
+		return TokenClaims{}, err
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return TokenClaims{}, ErrInvalidToken
+	}
+
+	var claims TokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return TokenClaims{}, ErrInvalidToken
+	}
+
+	if err := v.validateClaims(claims); err != nil {
+		return TokenClaims{}, err
+	}
+
+	return claims, nil
+}
+
+func (v *JWTVerifier) verifySignature(alg, kid string, signingInput, signature []byte) error {
+	switch alg {
+	case "HS256":
+		if len(v.HMACSecret) == 0 {
+			return ErrInvalidToken
+		}
+		mac := hmac.New(sha256.New, v.HMACSecret)
+		mac.Write(signingInput)
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return ErrInvalidToken
+		}
+		return nil
+	case "RS256":
+		key, ok := v.RSAKeys[kid]
+		if !ok {
+			return ErrInvalidToken
+		}
+		digest := sha256.Sum256(signingInput)
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+			return ErrInvalidToken
+		}
+		return nil
+	default:
+		return ErrInvalidToken
+	}
+}
+
+func (v *JWTVerifier) validateClaims(claims TokenClaims) error {
+	now := time.Now()
+	if v.Now != nil {
+		now = v.Now()
+	}
+
+	if claims.ExpiresAt == 0 || now.After(time.Unix(claims.ExpiresAt, 0).Add(v.Leeway)) {
+		return ErrTokenExpired
+	}
+
+	if claims.NotBefore != 0 && now.Add(v.Leeway).Before(time.Unix(claims.NotBefore, 0)) {
+		return ErrInvalidToken
+	}
+
+	if v.Audience != "" && !claims.Audience.contains(v.Audience) {
+		return ErrInvalidToken
+	}
+
+	if v.Issuer != "" && claims.Issuer != v.Issuer {
+		return ErrInvalidToken
+	}
+
+	if claims.Subject == "" {
+		return ErrInvalidToken
+	}
+
+	return nil
+}
+
+// LoadJWKS reads the RSA signing keys from a JWKS document on disk, keyed by
+// their kid.
+func LoadJWKS(path string) (map[string]*rsa.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read jwks file: %w", err)
+	}
+
+	var document struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("parse jwks file: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(document.Keys))
+	for _, jwk := range document.Keys {
+		if jwk.Kty != "RSA" {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, fmt.Errorf("decode jwks key %q modulus: %w", jwk.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			return nil, fmt.Errorf("decode jwks key %q exponent: %w", jwk.Kid, err)
+		}
+
+		keys[jwk.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	return keys, nil
+}
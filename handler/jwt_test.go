@@ -0,0 +1,150 @@
+package handler
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+var testNow = time.Unix(1_700_000_000, 0)
+
+func TestJWTVerifierVerify(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret := []byte("test-secret")
+	verifier := &JWTVerifier{
+		HMACSecret: secret,
+		RSAKeys:    map[string]*rsa.PublicKey{"key-1": &rsaKey.PublicKey},
+		Audience:   "zssf",
+		Issuer:     "auth.example",
+		Leeway:     30 * time.Second,
+		Now:        func() time.Time { return testNow },
+	}
+
+	valid := map[string]any{
+		"sub":       "user-1",
+		"iss":       "auth.example",
+		"aud":       "zssf",
+		"exp":       testNow.Add(time.Hour).Unix(),
+		"accounts":  []string{"001234567890"},
+		"device_id": "device-1",
+	}
+	with := func(key string, value any) map[string]any {
+		claims := map[string]any{}
+		for k, v := range valid {
+			claims[k] = v
+		}
+		if value == nil {
+			delete(claims, key)
+		} else {
+			claims[key] = value
+		}
+		return claims
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{"hs256", signHS256(t, secret, valid), nil},
+		{"rs256", signRS256(t, rsaKey, "key-1", valid), nil},
+		{"audience array", signHS256(t, secret, with("aud", []string{"other", "zssf"})), nil},
+		{"expired within leeway", signHS256(t, secret, with("exp", testNow.Add(-10*time.Second).Unix())), nil},
+		{"not before within leeway", signHS256(t, secret, with("nbf", testNow.Add(10*time.Second).Unix())), nil},
+
+		{"wrong hmac secret", signHS256(t, []byte("other-secret"), valid), ErrInvalidToken},
+		{"rs256 wrong key", signRS256(t, otherKey, "key-1", valid), ErrInvalidToken},
+		{"rs256 unknown kid", signRS256(t, rsaKey, "key-2", valid), ErrInvalidToken},
+		{"alg none", encodeToken(t, map[string]string{"alg": "none"}, valid, nil), ErrInvalidToken},
+		{"expired", signHS256(t, secret, with("exp", testNow.Add(-time.Minute).Unix())), ErrTokenExpired},
+		{"no expiry", signHS256(t, secret, with("exp", nil)), ErrTokenExpired},
+		{"not yet valid", signHS256(t, secret, with("nbf", testNow.Add(time.Minute).Unix())), ErrInvalidToken},
+		{"wrong audience", signHS256(t, secret, with("aud", "other")), ErrInvalidToken},
+		{"wrong issuer", signHS256(t, secret, with("iss", "evil.example")), ErrInvalidToken},
+		{"no subject", signHS256(t, secret, with("sub", nil)), ErrInvalidToken},
+		{"two parts", "a.b", ErrInvalidToken},
+		{"bad base64", "!!.!!.!!", ErrInvalidToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := verifier.Verify(tt.token)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Verify() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if claims.Subject != "user-1" || claims.DeviceUniqueID != "device-1" || len(claims.Accounts) != 1 {
+				t.Errorf("Verify() claims = %+v", claims)
+			}
+		})
+	}
+}
+
+func TestJWTVerifierVerifyWithoutHMACSecret(t *testing.T) {
+	verifier := &JWTVerifier{Now: func() time.Time { return testNow }}
+
+	token := signHS256(t, nil, map[string]any{"sub": "user-1", "exp": testNow.Add(time.Hour).Unix()})
+	if _, err := verifier.Verify(token); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("Verify() error = %v, want %v", err, ErrInvalidToken)
+	}
+}
+
+func signHS256(t *testing.T, secret []byte, claims map[string]any) string {
+	t.Helper()
+	return encodeToken(t, map[string]string{"alg": "HS256", "typ": "JWT"}, claims, func(input []byte) []byte {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(input)
+		return mac.Sum(nil)
+	})
+}
+
+func signRS256(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]any) string {
+	t.Helper()
+	return encodeToken(t, map[string]string{"alg": "RS256", "typ": "JWT", "kid": kid}, claims, func(input []byte) []byte {
+		digest := sha256.Sum256(input)
+		signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signature
+	})
+}
+
+// encodeToken builds header.payload.signature; a nil sign leaves the
+// signature empty.
+func encodeToken(t *testing.T, header map[string]string, claims map[string]any, sign func([]byte) []byte) string {
+	t.Helper()
+
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString(claimsJSON)
+	var signature []byte
+	if sign != nil {
+		signature = sign([]byte(input))
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
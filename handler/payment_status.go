@@ -0,0 +1,172 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/leopardquick/zssf/billgateway"
+	"github.com/leopardquick/zssf/logging"
+	"github.com/leopardquick/zssf/model"
+	"github.com/leopardquick/zssf/store"
+)
+
+const (
+	// staleSubmittedAfter is how long a payment may stay SUBMITTED before the
+	// reconciler assumes the process handling it died mid-call.
+	staleSubmittedAfter = 5 * time.Minute
+	reconcileBatchSize  = 50
+	// maxPollBackoff caps the wait between status queries for one payment.
+	maxPollBackoff = time.Hour
+)
+
+// PaymentStatus returns the ledger record for one of the caller's payments.
+func (cn *ControlNumberHandler) PaymentStatus(w http.ResponseWriter, r *http.Request) {
+	userID := requestUserID(r, "known")
+	requestID := chi.URLParam(r, "requestId")
+
+	if cn.Payments == nil {
+		writeError(w, r, internalError("payment store is not configured"))
+		return
+	}
+
+	payment, err := cn.Payments.GetByRequestID(r.Context(), requestID)
+	if err != nil {
+		if errors.Is(err, store.ErrPaymentNotFound) {
+			writeError(w, r, newAPIError(http.StatusNotFound, CodePaymentNotFound, "payment not found"))
+			return
+		}
+		cn.Logger.ErrorContext(r.Context(), "error loading payment", logging.KeyError, err)
+		writeError(w, r, internalError("failed to process request"))
+		return
+	}
+
+	// other users' payments are reported as missing rather than forbidden so
+	// request IDs cannot be probed
+	if payment.UserID != userID {
+		writeError(w, r, newAPIError(http.StatusNotFound, CodePaymentNotFound, "payment not found"))
+		return
+	}
+
+	ResponseWithJSON(w, http.StatusOK, paymentStatusResponse(payment))
+}
+
+func paymentStatusResponse(payment store.Payment) model.PaymentStatusResponse {
+	return model.PaymentStatusResponse{
+		RequestID:     payment.RequestID,
+		ControlNo:     payment.ControlNo,
+		State:         payment.State,
+		DebitAccount:  payment.DebitAccount,
+		CreditAccount: payment.CreditAccount,
+		Amount:        payment.Amount,
+		Currency:      payment.Currency,
+		GatewayRefId:  payment.GatewayRefID,
+		ReceiptNo:     payment.ReceiptNo,
+		StatusMessage: payment.GatewayStatusMessage,
+		CreatedAt:     payment.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:     payment.UpdatedAt.Format(time.RFC3339),
+	}
+}
+
+// RunPaymentReconciler resolves UNKNOWN payments every interval until ctx is
+// cancelled.
+func (cn *ControlNumberHandler) RunPaymentReconciler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := cn.ReconcilePayments(ctx); err != nil {
+				cn.Logger.ErrorContext(ctx, "error reconciling payments", logging.KeyError, err)
+			}
+		}
+	}
+}
+
+// ReconcilePayments moves payments stuck in SUBMITTED to UNKNOWN and then asks
+// the gateway for the outcome of every UNKNOWN payment that is due a query.
+func (cn *ControlNumberHandler) ReconcilePayments(ctx context.Context) error {
+	if cn.Payments == nil {
+		return errors.New("payment store is not configured")
+	}
+
+	now := time.Now()
+
+	stale, err := cn.Payments.ListByState(ctx, store.PaymentStateSubmitted, now.Add(-staleSubmittedAfter), reconcileBatchSize)
+	if err != nil {
+		return err
+	}
+	for _, payment := range stale {
+		cn.transitionPayment(ctx, payment.RequestID, store.PaymentStateSubmitted, store.PaymentStateUnknown, store.PaymentUpdate{GatewayStatusMessage: "no response recorded"})
+	}
+
+	due, err := cn.Payments.ListDueForPoll(ctx, now, reconcileBatchSize)
+	if err != nil {
+		return err
+	}
+	for _, payment := range due {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		statusResponse, err := cn.Gateway.PaymentStatus(ctx, payment.RequestID)
+
+		var statusErr *billgateway.StatusError
+		switch {
+		case err == nil:
+			cn.transitionPayment(ctx, payment.RequestID, store.PaymentStateUnknown, store.PaymentStateSucceeded, store.PaymentUpdate{
+				GatewayStatusID:      statusResponse.StatusId,
+				GatewayStatusMessage: statusResponse.StatusMessage,
+				GatewayRefID:         statusResponse.Data.GatewayRefId,
+				ReceiptNo:            statusResponse.Data.ReceiptNo,
+			})
+		case errors.As(err, &statusErr) && cn.Statuses.PaymentFailed(statusErr.StatusID):
+			cn.transitionPayment(ctx, payment.RequestID, store.PaymentStateUnknown, store.PaymentStateFailed, store.PaymentUpdate{
+				GatewayStatusID:      statusErr.StatusID,
+				GatewayStatusMessage: statusErr.StatusMessage,
+			})
+		default:
+			// still unknown: a transport error, or a statusId such as "not
+			// found" or "busy" that says nothing about the payment
+			cn.Logger.WarnContext(ctx, "payment status unresolved", "payment_request_id", payment.RequestID, logging.KeyError, err)
+			cn.deferPoll(ctx, payment, now, err)
+		}
+	}
+
+	return nil
+}
+
+// deferPoll schedules the next status query for a payment that is still
+// UNKNOWN, doubling the wait each time. After PaymentReconcileMaxAttempts
+// queries the payment is flagged for manual review and no longer queried.
+func (cn *ControlNumberHandler) deferPoll(ctx context.Context, payment store.Payment, now time.Time, cause error) {
+	attempts := payment.PollAttempts + 1
+	needsReview := attempts >= cn.Config.PaymentReconcileMaxAttempts
+
+	interval := time.Duration(cn.Config.PaymentReconcileIntervalSeconds) * time.Second
+	next := now.Add(pollBackoff(interval, attempts))
+
+	if err := cn.Payments.DeferPoll(ctx, payment.RequestID, next, needsReview, cause.Error()); err != nil {
+		cn.Logger.ErrorContext(ctx, "error recording payment status query", "payment_request_id", payment.RequestID, logging.KeyError, err)
+		return
+	}
+
+	if needsReview {
+		cn.Logger.ErrorContext(ctx, "payment outcome unresolved, manual review required", "payment_request_id", payment.RequestID, "attempts", attempts)
+	}
+}
+
+// pollBackoff is interval doubled for every attempt after the first, capped
+// at maxPollBackoff.
+func pollBackoff(interval time.Duration, attempts int) time.Duration {
+	backoff := interval
+	for i := 1; i < attempts && backoff < maxPollBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, maxPollBackoff)
+}
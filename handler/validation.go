@@ -0,0 +1,99 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/leopardquick/zssf/model"
+	"github.com/leopardquick/zssf/validate"
+)
+
+// FieldError explains why one request field was rejected.
+type FieldError = validate.FieldError
+
+// decodeRequest unmarshals body into v and checks it against its validate
+// tags, normalizing fields in place. With strict set, fields v does not
+// declare are rejected. Failures are returned as an APIError listing every
+// bad field.
+func decodeRequest(body []byte, v any, strict bool) error {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	if strict {
+		decoder.DisallowUnknownFields()
+	}
+
+	if err := decoder.Decode(v); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		switch {
+		case errors.As(err, &typeErr) && typeErr.Type == moneyType:
+			// encoding/json does not name the field for errors from
+			// UnmarshalJSON, so fall back to v's only Money field
+			field := typeErr.Field
+			if field == "" {
+				field = moneyField(v)
+			}
+			if field == "" {
+				return newAPIError(http.StatusBadRequest, CodeInvalidRequest, "invalid request payload")
+			}
+			return invalidFields(FieldError{Field: field, Message: "must be a positive amount with at most two decimal places"})
+		case errors.As(err, &typeErr) && typeErr.Field != "":
+			return invalidFields(FieldError{Field: typeErr.Field, Message: "must be a " + typeErr.Type.String()})
+		case strings.HasPrefix(err.Error(), "json: unknown field "):
+			field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+			return invalidFields(FieldError{Field: field, Message: "is not allowed"})
+		default:
+			return newAPIError(http.StatusBadRequest, CodeInvalidRequest, "invalid request payload")
+		}
+	}
+
+	if errs := validate.Struct(v); len(errs) > 0 {
+		return invalidFields(errs...)
+	}
+
+	return nil
+}
+
+var moneyType = reflect.TypeOf(model.Money{})
+
+// moneyField returns the json name of the single model.Money field in the
+// struct v points to, or "" if it has none or several.
+func moneyField(v any) string {
+	t := reflect.TypeOf(v)
+	if t.Kind() != reflect.Pointer || t.Elem().Kind() != reflect.Struct {
+		return ""
+	}
+	t = t.Elem()
+
+	name := ""
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Type != moneyType {
+			continue
+		}
+		if name != "" {
+			return ""
+		}
+		name, _, _ = strings.Cut(field.Tag.Get("json"), ",")
+		if name == "" {
+			name = field.Name
+		}
+	}
+	return name
+}
+
+func invalidFields(errs ...FieldError) APIError {
+	message := make([]string, len(errs))
+	for i, err := range errs {
+		message[i] = err.Field + " " + err.Message
+	}
+
+	return APIError{
+		Status:  http.StatusBadRequest,
+		Code:    CodeValidationFailed,
+		Message: strings.Join(message, "; "),
+		Details: errs,
+	}
+}
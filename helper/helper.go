@@ -1,15 +1,18 @@
 package helper
 
 import (
+	"context"
 	"crypto/rand"
 	"database/sql"
 	"encoding/hex"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"time"
 
+	"github.com/leopardquick/zssf/logging"
 	"github.com/leopardquick/zssf/model"
+	"github.com/leopardquick/zssf/store"
 )
 
 func GenerateReferenceNumber() string {
@@ -21,41 +24,73 @@ func GenerateReferenceNumber() string {
 	return fmt.Sprintf("%d", time.Now().UnixNano())
 }
 
+// InsertActivityLog writes entry to the log. It is the fallback when no
+// activity log writer is configured; handlers record entries through
+// activity.Writer, which persists them to activity_logs.
 func InsertActivityLog(entry model.ActivityLog) {
-	log.Printf("activity_log user=%s msg=%s", entry.UserID, entry.LogMessage)
+	slog.Info("activity", "user_id", entry.UserID, "message", entry.LogMessage)
 }
 
 type DBHelper struct {
-	logger *log.Logger
+	logger *slog.Logger
 	db     *sql.DB
 }
 
-func NewDBHelper(logger *log.Logger, db *sql.DB) *DBHelper {
+func NewDBHelper(logger *slog.Logger, db *sql.DB) *DBHelper {
 	if logger == nil {
-		logger = log.Default()
+		logger = slog.Default()
 	}
 
 	return &DBHelper{logger: logger, db: db}
 }
 
+// InsertActivityLog stores entry in activity_logs synchronously, falling back
+// to the log when the insert fails.
 func (h *DBHelper) InsertActivityLog(entry model.ActivityLog) {
-	InsertActivityLog(entry)
+	err := store.NewSQLActivityLogStore(h.db).CreateBatch(context.Background(), []store.ActivityLog{{
+		UserID:  entry.UserID,
+		Message: entry.LogMessage,
+	}})
+	if err != nil {
+		h.logger.Error("error writing activity log", logging.KeyError, err)
+		InsertActivityLog(entry)
+	}
 }
 
 func (h *DBHelper) GetAccountsByUserID(userID string) ([]model.Account, error) {
-	return nil, errors.New("GetAccountsByUserID is not implemented")
+	accounts, err := store.NewSQLAccountStore(h.db).ListByUserID(context.Background(), userID)
+	if err != nil {
+		return nil, err
+	}
+
+	output := make([]model.Account, 0, len(accounts))
+	for _, account := range accounts {
+		output = append(output, model.Account{ID: account.ID, AccountNumber: account.AccountNumber})
+	}
+
+	return output, nil
 }
 
 func (h *DBHelper) GetUserByID(userID string) (model.User, error) {
-	return model.User{}, errors.New("GetUserByID is not implemented")
+	user, err := store.NewSQLUserStore(h.db).GetByID(context.Background(), userID)
+	if err != nil {
+		return model.User{}, err
+	}
+
+	return model.User{ID: user.UserID, Password: user.PinHash}, nil
 }
 
 func (h *DBHelper) GetLoginAttempts(userID string) (int, error) {
-	return 0, errors.New("GetLoginAttempts is not implemented")
+	user, err := store.NewSQLUserStore(h.db).GetByID(context.Background(), userID)
+	if err != nil {
+		return 0, err
+	}
+
+	return user.FailedPinAttempts, nil
 }
 
 func (h *DBHelper) ResetLoginAttempts(userID string) error {
-	return errors.New("ResetLoginAttempts is not implemented")
+	return store.NewSQLUserStore(h.db).ResetFailedPinAttempts(context.Background(), userID)
 }
 
 func (h *DBHelper) VerifyUser(accountNumber string) (model.AccountVerificationRespond, error) {
@@ -67,7 +102,7 @@ func (h *DBHelper) ContainsAccountNumber(accountNumber string, accounts []model.
 }
 
 func (h *DBHelper) UpdateUserStatus(userID, status string) error {
-	return UpdateUserStatus(userID, status)
+	return store.NewSQLUserStore(h.db).UpdateStatus(context.Background(), userID, status)
 }
 
 func (h *DBHelper) DecryptPassword(pin, encryptedPassword string) error {
@@ -91,16 +126,14 @@ func ContainsAccountNumber(accountNumber string, accounts []model.Account) bool
 	return false
 }
 
-func UpdateUserStatus(userID, status string) error {
-	return errors.New("UpdateUserStatus is not implemented")
-}
-
+// DecryptPassword verifies pin against its stored hash. The hash is one-way;
+// the name is kept for existing callers.
 func DecryptPassword(pin, encryptedPassword string) error {
-	return errors.New("DecryptPassword is not implemented")
+	return VerifyPin(pin, encryptedPassword)
 }
 
 func InsertRequestLog(entry model.RequestLog) {
-	log.Printf("request_log request_id=%s status=%d", entry.RequestID, entry.ResponseStatusCode)
+	slog.Info("request log", "request_id", entry.RequestID, "status", entry.ResponseStatusCode)
 }
 
 func InsertTransactionModel(entry model.TransactionModel) error {
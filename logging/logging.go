@@ -0,0 +1,131 @@
+// Package logging builds the service's structured JSON logger. Every record
+// carries the request ID, trace ID, route and any per-request attributes
+// found on its context, and sensitive fields are redacted before they are
+// written.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Attribute keys shared by the service's log records.
+const (
+	KeyRequestID = "request_id"
+	KeyTraceID   = "trace_id"
+	KeyUserID    = "user_id"
+	KeyRoute     = "route"
+	KeyUpstream  = "upstream"
+	KeyLatency   = "latency_ms"
+	KeyError     = "error"
+)
+
+// New returns a JSON logger writing records at level and above to w.
+func New(w io.Writer, level slog.Level) *slog.Logger {
+	handler := slog.NewJSONHandler(w, &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: redactAttr,
+	})
+	return slog.New(contextHandler{Handler: handler})
+}
+
+// ParseLevel parses debug, info, warn or error, ignoring case. An empty
+// value is info.
+func ParseLevel(value string) (slog.Level, error) {
+	var level slog.Level
+	if strings.TrimSpace(value) == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := level.UnmarshalText([]byte(strings.TrimSpace(value))); err != nil {
+		return 0, fmt.Errorf("invalid log level %q", value)
+	}
+	return level, nil
+}
+
+type fieldsKey struct{}
+
+// fields holds attributes learned while a request is served, such as the
+// authenticated user. Middleware installs one per request.
+type fields struct {
+	mu    sync.Mutex
+	attrs []slog.Attr
+}
+
+// AddAttrs records attrs on the request behind ctx so every later record
+// logged with that context, including the access log line, carries them. An
+// attribute replaces an earlier one with the same key. It is a no-op outside
+// Middleware.
+func AddAttrs(ctx context.Context, attrs ...slog.Attr) {
+	f, ok := ctx.Value(fieldsKey{}).(*fields)
+	if !ok {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+next:
+	for _, attr := range attrs {
+		for i := range f.attrs {
+			if f.attrs[i].Key == attr.Key {
+				f.attrs[i] = attr
+				continue next
+			}
+		}
+		f.attrs = append(f.attrs, attr)
+	}
+}
+
+func fieldsFrom(ctx context.Context) []slog.Attr {
+	f, ok := ctx.Value(fieldsKey{}).(*fields)
+	if !ok {
+		return nil
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]slog.Attr(nil), f.attrs...)
+}
+
+// contextHandler adds the correlation attributes found on the record's
+// context before handing it on.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if ctx == nil {
+		return h.Handler.Handle(ctx, record)
+	}
+
+	if id := middleware.GetReqID(ctx); id != "" {
+		record.AddAttrs(slog.String(KeyRequestID, id))
+	}
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.HasTraceID() {
+		record.AddAttrs(slog.String(KeyTraceID, spanContext.TraceID().String()))
+	}
+	if rctx := chi.RouteContext(ctx); rctx != nil {
+		if route := rctx.RoutePattern(); route != "" {
+			record.AddAttrs(slog.String(KeyRoute, route))
+		}
+	}
+	record.AddAttrs(fieldsFrom(ctx)...)
+
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{Handler: h.Handler.WithGroup(name)}
+}
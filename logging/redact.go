@@ -0,0 +1,76 @@
+package logging
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/leopardquick/zssf/redact"
+)
+
+// secretKeys are attribute and header names whose values are never logged.
+// Names are compared after normalizeKey.
+var secretKeys = map[string]bool{
+	"pin":           true,
+	"securitycode":  true,
+	"password":      true,
+	"authorization": true,
+	"token":         true,
+	"secret":        true,
+	"hmacsecret":    true,
+	"apikey":        true,
+	"xapikey":       true,
+	"xsignature":    true,
+	"cookie":        true,
+}
+
+// accountKeys are attribute names holding account numbers, which are masked
+// to their last four digits.
+var accountKeys = map[string]bool{
+	"account":       true,
+	"accountnumber": true,
+	"debitaccount":  true,
+	"creditaccount": true,
+}
+
+// normalizeKey lower-cases key and drops separators so "securityCode",
+// "security_code" and "Security-Code" compare equal.
+func normalizeKey(key string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '_', '-', ' ':
+			return -1
+		}
+		return r
+	}, strings.ToLower(key))
+}
+
+// redactAttr is the JSON handler's ReplaceAttr hook.
+func redactAttr(_ []string, attr slog.Attr) slog.Attr {
+	key := normalizeKey(attr.Key)
+	switch {
+	case secretKeys[key]:
+		return slog.String(attr.Key, redact.Redacted)
+	case accountKeys[key]:
+		return slog.String(attr.Key, redact.Account(attr.Value.String()))
+	}
+
+	if header, ok := attr.Value.Any().(http.Header); ok {
+		return slog.Any(attr.Key, RedactHeaders(header))
+	}
+	return attr
+}
+
+// RedactHeaders returns a copy of header with credential headers replaced by
+// [REDACTED].
+func RedactHeaders(header http.Header) http.Header {
+	out := make(http.Header, len(header))
+	for name, values := range header {
+		if secretKeys[normalizeKey(name)] {
+			out[name] = []string{redact.Redacted}
+			continue
+		}
+		out[name] = values
+	}
+	return out
+}
@@ -4,7 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"errors"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
@@ -13,65 +13,171 @@ import (
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/leopardquick/zssf/activity"
+	"github.com/leopardquick/zssf/billgateway"
+	"github.com/leopardquick/zssf/corebanking"
 	"github.com/leopardquick/zssf/handler"
+	"github.com/leopardquick/zssf/logging"
+	"github.com/leopardquick/zssf/metrics"
+	"github.com/leopardquick/zssf/outbound"
+	"github.com/leopardquick/zssf/redact"
+	"github.com/leopardquick/zssf/requestlog"
 	"github.com/leopardquick/zssf/setup"
 	"github.com/leopardquick/zssf/store"
+	"github.com/leopardquick/zssf/tracing"
 
 	_ "github.com/lib/pq"
 )
 
 const (
-	serverAddr     = ":2080"
-	shutdownTimout = 10 * time.Second
+	shutdownTimout  = 10 * time.Second
+	cleanupInterval = 10 * time.Minute
 )
 
 func main() {
-	logger := log.New(os.Stdout, "", log.LstdFlags)
+	logger := logging.New(os.Stdout, slog.LevelInfo)
+
+	cfg, err := setup.Load()
+	if err != nil {
+		fatal(logger, "invalid configuration", err)
+	}
+
+	// the level was checked by setup.Load
+	level, _ := logging.ParseLevel(cfg.LogLevel)
+	logger = logging.New(os.Stdout, level)
+	slog.SetDefault(logger)
+
+	secrets, err := setup.NewSecretProvider(cfg)
+	if err != nil {
+		fatal(logger, "failed to initialise secrets provider", err)
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Options{
+		Exporter: cfg.TracingExporter,
+		Endpoint: cfg.TracingEndpoint,
+	})
+	if err != nil {
+		fatal(logger, "failed to initialise tracing", err)
+	}
+
+	appMetrics := metrics.New()
 
 	router := chi.NewRouter()
 	router.Use(middleware.RequestID)
+	router.Use(tracing.Middleware)
+	router.Use(appMetrics.Middleware)
 	router.Use(middleware.RealIP)
-	router.Use(middleware.Logger)
+	router.Use(logging.Middleware(logger))
 	router.Use(middleware.Recoverer)
 
-	dsn := setup.DatabaseDSN()
-	if dsn == "" {
-		logger.Fatal("DATABASE_URL is required")
-	}
-
-	db, err := sql.Open(setup.DatabaseDriver(), dsn)
+	db, err := sql.Open(cfg.DatabaseDriver, cfg.DatabaseURL)
 	if err != nil {
-		logger.Fatalf("failed to open database: %v", err)
+		fatal(logger, "failed to open database", err)
 	}
 	defer db.Close()
 
 	ctxPing, cancelPing := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancelPing()
 	if err := db.PingContext(ctxPing); err != nil {
-		logger.Fatalf("failed to ping database: %v", err)
+		fatal(logger, "failed to ping database", err)
+	}
+
+	jwtVerifier, err := newJWTVerifier(context.Background(), cfg, secrets)
+	if err != nil {
+		fatal(logger, "failed to configure token authentication", err)
+	}
+
+	authenticator := handler.NewAuthenticator(store.NewSQLAPIClientStore(db))
+	authenticator.JWT = jwtVerifier
+	appMetrics.WatchDB("zssf", db)
+
+	var requestLogBase store.RequestLogStore = store.NewSQLRequestLogStore(db)
+	var requestLogWriter *requestlog.Writer
+	if cfg.RequestLogAsync {
+		requestLogWriter, err = requestlog.NewWriter(store.NewSQLRequestLogStore(db), requestlog.Options{
+			QueueSize:      cfg.RequestLogQueueSize,
+			BatchSize:      cfg.RequestLogBatchSize,
+			FlushInterval:  time.Duration(cfg.RequestLogFlushIntervalMs) * time.Millisecond,
+			JournalDir:     cfg.RequestLogJournalDir,
+			ReplayInterval: time.Duration(cfg.RequestLogReplayIntervalSeconds) * time.Second,
+			Logger:         logger,
+		})
+		if err != nil {
+			fatal(logger, "failed to start request log writer", err)
+		}
+		appMetrics.WatchRequestLogWriter(requestLogWriter)
+		requestLogBase = requestLogWriter
+	}
+
+	requestLogStore := appMetrics.RequestLogs(tracing.RequestLogs(requestLogBase))
+	accountStore := tracing.Accounts(store.NewSQLAccountStore(db))
+	userStore := store.NewSQLUserStore(db)
+	paymentStore := store.NewSQLPaymentStore(db)
+	idempotencyStore := store.NewSQLIdempotencyStore(db)
+	billStore := store.NewSQLBillStore(db)
+	coreBankingTransport := outbound.New("core_banking", upstreamPolicy(cfg.CoreBankingUpstream), tracing.Transport("core_banking", nil))
+	gatewayTransport := outbound.New("bill_gateway", upstreamPolicy(cfg.GatewayUpstream), tracing.Transport("bill_gateway", nil))
+
+	appMetrics.WatchTransports(coreBankingTransport, gatewayTransport)
+
+	coreBanking := appMetrics.CoreBanking(corebanking.New(cfg.AccountVerificationURL, secrets, &http.Client{Timeout: 15 * time.Second, Transport: coreBankingTransport}))
+	apiHandler := handler.New(cfg, secrets, requestLogStore, accountStore, idempotencyStore, coreBanking, logger)
+	gateway := appMetrics.Gateway(billgateway.New(cfg.BaseURL, cfg.ChannelCode, secrets, &http.Client{Timeout: 40 * time.Second, Transport: gatewayTransport}))
+	controlNumberHandler := handler.NewControlNumberHandler(cfg, secrets, requestLogStore, accountStore, userStore, paymentStore, idempotencyStore, billStore, coreBanking, gateway, logger)
+	if cfg.GatewayStatusFile != "" {
+		statuses, err := billgateway.LoadCatalogue(cfg.GatewayStatusFile)
+		if err != nil {
+			fatal(logger, "failed to load gateway status catalogue", err)
+		}
+		controlNumberHandler.Statuses = statuses
+	}
+
+	redaction, err := redactionPolicy(context.Background(), cfg, secrets)
+	if err != nil {
+		fatal(logger, "failed to load request log redaction policy", err)
 	}
+	apiHandler.Redaction = redaction
+	controlNumberHandler.Redaction = redaction
 
-	requestLogStore := store.NewSQLRequestLogStore(db)
-	accountStore := store.NewSQLAccountStore(db)
-	apiHandler := handler.New(&http.Client{Timeout: 15 * time.Second}, requestLogStore, accountStore)
-	controlNumberHandler := handler.NewControlNumberHandler(&http.Client{Timeout: 40 * time.Second}, requestLogStore, accountStore)
+	activityLog := activity.NewWriter(store.NewSQLActivityLogStore(db), activity.Options{
+		QueueSize:     cfg.ActivityLogQueueSize,
+		BatchSize:     cfg.ActivityLogBatchSize,
+		FlushInterval: time.Duration(cfg.ActivityLogFlushIntervalMs) * time.Millisecond,
+		Logger:        logger,
+	})
+	appMetrics.WatchActivityLog(activityLog)
+	apiHandler.Activity = activityLog
+	controlNumberHandler.Activity = activityLog
+	apiHandler.Conflicts = appMetrics
+	controlNumberHandler.Conflicts = appMetrics
 
 	router.Get("/healthz", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		_, _ = w.Write([]byte("ok"))
 	})
 
+	router.Get("/healthz/upstreams", func(w http.ResponseWriter, r *http.Request) {
+		handler.ResponseWithJSON(w, http.StatusOK, []outbound.Stats{coreBankingTransport.Stats(), gatewayTransport.Stats()})
+	})
+
+	router.Handle("/metrics", appMetrics.Handler())
+
 	router.Get("/", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		_, _ = w.Write([]byte("hello from chi"))
 	})
 
-	router.Post("/account-balance", apiHandler.AccountBalance)
-	router.Post("/control-number/enquire", controlNumberHandler.Enquire)
-	router.Post("/control-number/payment", controlNumberHandler.PaymentPost)
+	router.Group(func(r chi.Router) {
+		r.Use(authenticator.Middleware)
+
+		r.Post("/account-balance", apiHandler.AccountBalance)
+		r.Post("/control-number/enquire", controlNumberHandler.Enquire)
+		r.Post("/control-number/payment", controlNumberHandler.PaymentPost)
+		r.Get("/control-number/payment/{requestId}", controlNumberHandler.PaymentStatus)
+	})
 
 	server := &http.Server{
-		Addr:    serverAddr,
+		Addr:    cfg.ServerAddr,
 		Handler: router,
 	}
 
@@ -79,24 +185,132 @@ func main() {
 	defer stop()
 
 	go func() {
-		logger.Printf("server listening on %s", serverAddr)
+		ticker := time.NewTicker(cleanupInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := authenticator.PurgeNonces(ctx); err != nil {
+					logger.Error("failed to purge api client nonces", logging.KeyError, err)
+				}
+				if err := controlNumberHandler.PurgeBills(ctx); err != nil {
+					logger.Error("failed to purge bill enquiries", logging.KeyError, err)
+				}
+			}
+		}
+	}()
+
+	go controlNumberHandler.RunPaymentReconciler(ctx, time.Duration(cfg.PaymentReconcileIntervalSeconds)*time.Second)
+
+	go func() {
+		logger.Info("server listening", "addr", cfg.ServerAddr)
 		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-			logger.Fatalf("server error: %v", err)
+			fatal(logger, "server error", err)
 		}
 	}()
 
 	<-ctx.Done()
-	logger.Printf("shutdown signal received")
+	logger.Info("shutdown signal received")
 
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimout)
 	defer cancel()
 
 	if err := server.Shutdown(shutdownCtx); err != nil {
-		logger.Printf("graceful shutdown failed: %v", err)
+		logger.Error("graceful shutdown failed", logging.KeyError, err)
 		if err := server.Close(); err != nil {
-			logger.Printf("server close failed: %v", err)
+			logger.Error("server close failed", logging.KeyError, err)
+		}
+	}
+
+	// after the server has stopped, so entries from the last requests are
+	// written too
+	if err := activityLog.Close(shutdownCtx); err != nil {
+		logger.Error("activity log drain failed", logging.KeyError, err)
+	}
+
+	if requestLogWriter != nil {
+		if err := requestLogWriter.Close(shutdownCtx); err != nil {
+			logger.Error("request log drain failed", logging.KeyError, err)
+		}
+	}
+
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		logger.Error("trace exporter shutdown failed", logging.KeyError, err)
+	}
+
+	logger.Info("server stopped")
+}
+
+// fatal logs err and exits, in place of log.Fatalf.
+func fatal(logger *slog.Logger, msg string, err error) {
+	logger.Error(msg, logging.KeyError, err)
+	os.Exit(1)
+}
+
+func upstreamPolicy(cfg setup.UpstreamConfig) outbound.Policy {
+	return outbound.Policy{
+		MaxAttempts:      cfg.RetryMaxAttempts,
+		BaseDelay:        time.Duration(cfg.RetryBaseDelayMs) * time.Millisecond,
+		MaxDelay:         time.Duration(cfg.RetryMaxDelayMs) * time.Millisecond,
+		FailureThreshold: cfg.BreakerFailures,
+		OpenFor:          time.Duration(cfg.BreakerOpenSeconds) * time.Second,
+		MaxConcurrent:    cfg.MaxConcurrent,
+		AcquireTimeout:   time.Duration(cfg.AcquireTimeoutMs) * time.Millisecond,
+	}
+}
+
+// redactionPolicy loads REQUEST_LOG_REDACTION_FILE, or the default policy, and
+// keys its hash action with the REQUEST_LOG_HASH_KEY secret when one is set.
+func redactionPolicy(ctx context.Context, cfg setup.Config, secrets setup.SecretProvider) (*redact.Policy, error) {
+	policy := redact.DefaultPolicy()
+	if cfg.RequestLogRedactionFile != "" {
+		var err error
+		if policy, err = redact.LoadPolicy(cfg.RequestLogRedactionFile); err != nil {
+			return nil, err
+		}
+	}
+
+	hashKey, err := secrets.Secret(ctx, setup.SecretRequestLogHashKey)
+	if err != nil && !errors.Is(err, setup.ErrSecretNotFound) {
+		return nil, err
+	}
+	policy.HashKey = []byte(hashKey)
+
+	return policy, nil
+}
+
+// newJWTVerifier returns nil when neither an HS256 secret nor a JWKS file is
+// configured, which leaves only channel authentication enabled.
+func newJWTVerifier(ctx context.Context, cfg setup.Config, secrets setup.SecretProvider) (*handler.JWTVerifier, error) {
+	signingKey, err := secrets.Secret(ctx, setup.SecretJWTSigningKey)
+	if err != nil && !errors.Is(err, setup.ErrSecretNotFound) {
+		return nil, err
+	}
+
+	if signingKey == "" && cfg.JWTJWKSFile == "" {
+		return nil, nil
+	}
+
+	if cfg.JWTAudience == "" {
+		return nil, &setup.ValidationError{Missing: []string{"JWT_AUDIENCE"}}
+	}
+
+	verifier := &handler.JWTVerifier{
+		HMACSecret: []byte(signingKey),
+		Audience:   cfg.JWTAudience,
+		Issuer:     cfg.JWTIssuer,
+		Leeway:     30 * time.Second,
+	}
+
+	if cfg.JWTJWKSFile != "" {
+		keys, err := handler.LoadJWKS(cfg.JWTJWKSFile)
+		if err != nil {
+			return nil, err
 		}
+		verifier.RSAKeys = keys
 	}
 
-	logger.Printf("server stopped")
+	return verifier, nil
 }
@@ -0,0 +1,137 @@
+// Package metrics exposes Prometheus metrics for inbound requests, upstream
+// calls, request-log writes and the database pool.
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "zssf"
+
+// upstreamBuckets cover the bill gateway's 40s payment timeout.
+var upstreamBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20, 40}
+
+// Metrics owns a registry and the collectors the service updates. Use New.
+type Metrics struct {
+	Registry *prometheus.Registry
+
+	requests             *prometheus.CounterVec
+	requestDuration      *prometheus.HistogramVec
+	idempotencyConflicts *prometheus.CounterVec
+	upstreamCalls        *prometheus.CounterVec
+	upstreamErrors       *prometheus.CounterVec
+	upstreamDuration     *prometheus.HistogramVec
+	requestLogFailures   prometheus.Counter
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		Registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "Inbound HTTP requests by route, method and response status.",
+		}, []string{"route", "method", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Inbound HTTP request latency by route, method and response status.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method", "status"}),
+		idempotencyConflicts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "idempotency_conflicts_total",
+			Help:      "Requests refused because their request ID or idempotency key was already in use, by reason: in_progress (409) or key_reused (422).",
+		}, []string{"route", "reason"}),
+		upstreamCalls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "upstream_requests_total",
+			Help:      "Upstream calls by upstream, operation and gateway statusId (empty when none was returned).",
+		}, []string{"upstream", "operation", "status_id"}),
+		upstreamErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "upstream_errors_total",
+			Help:      "Failed upstream calls by upstream, operation and kind of failure.",
+		}, []string{"upstream", "operation", "kind"}),
+		upstreamDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "upstream_request_duration_seconds",
+			Help:      "Upstream call latency by upstream and operation, including retries.",
+			Buckets:   upstreamBuckets,
+		}, []string{"upstream", "operation"}),
+		requestLogFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "request_log_write_failures_total",
+			Help:      "Request log rows that could not be written.",
+		}),
+	}
+
+	m.Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests,
+		m.requestDuration,
+		m.idempotencyConflicts,
+		m.upstreamCalls,
+		m.upstreamErrors,
+		m.upstreamDuration,
+		m.requestLogFailures,
+	)
+
+	return m
+}
+
+// Handler serves the registry in the Prometheus text format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.Registry, promhttp.HandlerOpts{Registry: m.Registry})
+}
+
+// WatchDB exports the connection pool statistics of db.
+func (m *Metrics) WatchDB(name string, db *sql.DB) {
+	m.Registry.MustRegister(collectors.NewDBStatsCollector(db, name))
+}
+
+// Middleware records every request against its chi route pattern rather than
+// the raw path, so path parameters do not create new series.
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r)
+
+		route := routePattern(r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		code := strconv.Itoa(status)
+
+		m.requests.WithLabelValues(route, r.Method, code).Inc()
+		m.requestDuration.WithLabelValues(route, r.Method, code).Observe(time.Since(start).Seconds())
+	})
+}
+
+// IdempotencyConflict counts a request refused because its idempotency key
+// was already in use. It implements handler.ConflictRecorder.
+func (m *Metrics) IdempotencyConflict(r *http.Request, reason string) {
+	m.idempotencyConflicts.WithLabelValues(routePattern(r), reason).Inc()
+}
+
+// routePattern is r's chi route pattern, or "unmatched".
+func routePattern(r *http.Request) string {
+	if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+		return rctx.RoutePattern()
+	}
+	return "unmatched"
+}
@@ -0,0 +1,113 @@
+package metrics
+
+import (
+	"context"
+
+	"github.com/leopardquick/zssf/activity"
+	"github.com/leopardquick/zssf/requestlog"
+	"github.com/leopardquick/zssf/store"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RequestLogs wraps s so failed Create calls are counted.
+func (m *Metrics) RequestLogs(s store.RequestLogStore) store.RequestLogStore {
+	return &requestLogStore{RequestLogStore: s, m: m}
+}
+
+type requestLogStore struct {
+	store.RequestLogStore
+	m *Metrics
+}
+
+func (s *requestLogStore) Create(ctx context.Context, log store.RequestLog) error {
+	err := s.RequestLogStore.Create(ctx, log)
+	if err != nil {
+		s.m.requestLogFailures.Inc()
+	}
+	return err
+}
+
+// WatchActivityLog exports the activity log writer's queue depth and
+// counters, so a full queue dropping entries can be alerted on.
+func (m *Metrics) WatchActivityLog(w *activity.Writer) {
+	m.Registry.MustRegister(&activityLogCollector{writer: w})
+}
+
+var (
+	activityQueuedDesc = prometheus.NewDesc(namespace+"_activity_log_queue_depth",
+		"Activity log entries waiting to be written.", nil, nil)
+	activityCapacityDesc = prometheus.NewDesc(namespace+"_activity_log_queue_capacity",
+		"Activity log entries the queue can hold before dropping.", nil, nil)
+	activityWrittenDesc = prometheus.NewDesc(namespace+"_activity_log_written_total",
+		"Activity log entries written to the database.", nil, nil)
+	activityDroppedDesc = prometheus.NewDesc(namespace+"_activity_log_dropped_total",
+		"Activity log entries dropped because the queue was full or closed.", nil, nil)
+	activityFailedDesc = prometheus.NewDesc(namespace+"_activity_log_write_failures_total",
+		"Activity log entries whose batch insert failed.", nil, nil)
+)
+
+type activityLogCollector struct {
+	writer *activity.Writer
+}
+
+func (c *activityLogCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{activityQueuedDesc, activityCapacityDesc, activityWrittenDesc, activityDroppedDesc, activityFailedDesc} {
+		ch <- desc
+	}
+}
+
+func (c *activityLogCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.writer.Stats()
+	ch <- prometheus.MustNewConstMetric(activityQueuedDesc, prometheus.GaugeValue, float64(stats.Queued))
+	ch <- prometheus.MustNewConstMetric(activityCapacityDesc, prometheus.GaugeValue, float64(stats.Capacity))
+	ch <- prometheus.MustNewConstMetric(activityWrittenDesc, prometheus.CounterValue, float64(stats.Written))
+	ch <- prometheus.MustNewConstMetric(activityDroppedDesc, prometheus.CounterValue, float64(stats.Dropped))
+	ch <- prometheus.MustNewConstMetric(activityFailedDesc, prometheus.CounterValue, float64(stats.Failed))
+}
+
+// WatchRequestLogWriter exports the asynchronous request log writer's queue,
+// journal and counters.
+func (m *Metrics) WatchRequestLogWriter(w *requestlog.Writer) {
+	m.Registry.MustRegister(&requestLogWriterCollector{writer: w})
+}
+
+var (
+	requestLogQueuedDesc = prometheus.NewDesc(namespace+"_request_log_queue_depth",
+		"Request logs waiting for a batch.", nil, nil)
+	requestLogCapacityDesc = prometheus.NewDesc(namespace+"_request_log_queue_capacity",
+		"Request logs the queue can hold before Create inserts synchronously.", nil, nil)
+	requestLogPendingDesc = prometheus.NewDesc(namespace+"_request_log_pending",
+		"Request logs queued or being written; journaled ones are counted separately.", nil, nil)
+	requestLogJournaledDesc = prometheus.NewDesc(namespace+"_request_log_journaled",
+		"Request logs in the on-disk journal waiting for the database.", nil, nil)
+	requestLogWrittenDesc = prometheus.NewDesc(namespace+"_request_log_written_total",
+		"Request logs written to the database in batches.", nil, nil)
+	requestLogSynchronousDesc = prometheus.NewDesc(namespace+"_request_log_synchronous_total",
+		"Request logs inserted on the request path because the queue was full or closed.", nil, nil)
+	requestLogRejectedDesc = prometheus.NewDesc(namespace+"_request_log_rejected_total",
+		"Request logs the database refused, such as for a constraint violation.", nil, nil)
+	requestLogLostDesc = prometheus.NewDesc(namespace+"_request_log_lost_total",
+		"Request logs dropped because neither the database nor the journal took them.", nil, nil)
+)
+
+type requestLogWriterCollector struct {
+	writer *requestlog.Writer
+}
+
+func (c *requestLogWriterCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{requestLogQueuedDesc, requestLogCapacityDesc, requestLogPendingDesc, requestLogJournaledDesc, requestLogWrittenDesc, requestLogSynchronousDesc, requestLogRejectedDesc, requestLogLostDesc} {
+		ch <- desc
+	}
+}
+
+func (c *requestLogWriterCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.writer.Stats()
+	ch <- prometheus.MustNewConstMetric(requestLogQueuedDesc, prometheus.GaugeValue, float64(stats.Queued))
+	ch <- prometheus.MustNewConstMetric(requestLogCapacityDesc, prometheus.GaugeValue, float64(stats.Capacity))
+	ch <- prometheus.MustNewConstMetric(requestLogPendingDesc, prometheus.GaugeValue, float64(stats.Pending))
+	ch <- prometheus.MustNewConstMetric(requestLogJournaledDesc, prometheus.GaugeValue, float64(stats.Journaled))
+	ch <- prometheus.MustNewConstMetric(requestLogWrittenDesc, prometheus.CounterValue, float64(stats.Written))
+	ch <- prometheus.MustNewConstMetric(requestLogSynchronousDesc, prometheus.CounterValue, float64(stats.Synchronous))
+	ch <- prometheus.MustNewConstMetric(requestLogRejectedDesc, prometheus.CounterValue, float64(stats.Rejected))
+	ch <- prometheus.MustNewConstMetric(requestLogLostDesc, prometheus.CounterValue, float64(stats.Lost))
+}
@@ -0,0 +1,169 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/leopardquick/zssf/billgateway"
+	"github.com/leopardquick/zssf/corebanking"
+	"github.com/leopardquick/zssf/model"
+	"github.com/leopardquick/zssf/outbound"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	upstreamGateway     = "bill_gateway"
+	upstreamCoreBanking = "core_banking"
+)
+
+// Gateway wraps c so every call is counted by operation and statusId.
+func (m *Metrics) Gateway(c billgateway.Client) billgateway.Client {
+	return &gatewayClient{next: c, m: m}
+}
+
+type gatewayClient struct {
+	next billgateway.Client
+	m    *Metrics
+}
+
+func (g *gatewayClient) QueryBill(ctx context.Context, controlNo, requestID string) (model.EnquireResponse, error) {
+	start := time.Now()
+	response, err := g.next.QueryBill(ctx, controlNo, requestID)
+	g.m.observe(upstreamGateway, "bill/query", response.StatusId, start, gatewayErrorKind(err))
+	return response, err
+}
+
+func (g *gatewayClient) PostPayment(ctx context.Context, payment model.PaymentRequest) (model.ControlNumberPaymentResponse, error) {
+	start := time.Now()
+	response, err := g.next.PostPayment(ctx, payment)
+	g.m.observe(upstreamGateway, "payment/post", response.StatusId, start, gatewayErrorKind(err))
+	return response, err
+}
+
+func (g *gatewayClient) PaymentStatus(ctx context.Context, requestID string) (model.ControlNumberPaymentResponse, error) {
+	start := time.Now()
+	response, err := g.next.PaymentStatus(ctx, requestID)
+	g.m.observe(upstreamGateway, "payment/status", response.StatusId, start, gatewayErrorKind(err))
+	return response, err
+}
+
+func gatewayErrorKind(err error) string {
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, billgateway.ErrRejected):
+		return "rejected"
+	case errors.Is(err, outbound.ErrCircuitOpen):
+		return "circuit_open"
+	case errors.Is(err, outbound.ErrConcurrencyLimit):
+		return "throttled"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, billgateway.ErrNotSent):
+		return "not_sent"
+	default:
+		return "outcome_unknown"
+	}
+}
+
+// CoreBanking wraps c so every account verification is counted.
+func (m *Metrics) CoreBanking(c corebanking.Client) corebanking.Client {
+	return &coreBankingClient{next: c, m: m}
+}
+
+type coreBankingClient struct {
+	next corebanking.Client
+	m    *Metrics
+}
+
+func (c *coreBankingClient) VerifyAccount(ctx context.Context, accountNumber string) (corebanking.Account, error) {
+	start := time.Now()
+	account, err := c.next.VerifyAccount(ctx, accountNumber)
+	c.m.observe(upstreamCoreBanking, "account_verification", "", start, coreBankingErrorKind(err))
+	return account, err
+}
+
+func coreBankingErrorKind(err error) string {
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, corebanking.ErrAccountNotFound):
+		return "not_found"
+	case errors.Is(err, outbound.ErrCircuitOpen):
+		return "circuit_open"
+	case errors.Is(err, outbound.ErrConcurrencyLimit):
+		return "throttled"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, corebanking.ErrUnavailable):
+		return "unavailable"
+	default:
+		return "error"
+	}
+}
+
+func (m *Metrics) observe(upstream, operation, statusID string, start time.Time, errorKind string) {
+	m.upstreamDuration.WithLabelValues(upstream, operation).Observe(time.Since(start).Seconds())
+	m.upstreamCalls.WithLabelValues(upstream, operation, statusID).Inc()
+	if errorKind != "" {
+		m.upstreamErrors.WithLabelValues(upstream, operation, errorKind).Inc()
+	}
+}
+
+// WatchTransports exports the breaker state and counters of each outbound
+// transport.
+func (m *Metrics) WatchTransports(transports ...*outbound.Transport) {
+	m.Registry.MustRegister(&transportCollector{transports: transports})
+}
+
+var (
+	breakerStateDesc = prometheus.NewDesc(namespace+"_outbound_breaker_state",
+		"1 for the current circuit breaker state of the upstream, 0 for the others.", []string{"upstream", "state"}, nil)
+	breakerOpenedDesc = prometheus.NewDesc(namespace+"_outbound_breaker_opened_total",
+		"Times the upstream's circuit breaker opened.", []string{"upstream"}, nil)
+	inFlightDesc = prometheus.NewDesc(namespace+"_outbound_in_flight",
+		"Requests currently in flight to the upstream.", []string{"upstream"}, nil)
+	attemptsDesc = prometheus.NewDesc(namespace+"_outbound_attempts_total",
+		"HTTP attempts sent to the upstream, including retries.", []string{"upstream"}, nil)
+	attemptFailuresDesc = prometheus.NewDesc(namespace+"_outbound_attempt_failures_total",
+		"Attempts that failed with a transport error or 5xx.", []string{"upstream"}, nil)
+	retriesDesc = prometheus.NewDesc(namespace+"_outbound_retries_total",
+		"Retries of idempotent requests.", []string{"upstream"}, nil)
+	rejectedDesc = prometheus.NewDesc(namespace+"_outbound_rejected_total",
+		"Requests refused by an open circuit breaker.", []string{"upstream"}, nil)
+	throttledDesc = prometheus.NewDesc(namespace+"_outbound_throttled_total",
+		"Requests that timed out waiting for a concurrency slot.", []string{"upstream"}, nil)
+)
+
+type transportCollector struct {
+	transports []*outbound.Transport
+}
+
+func (c *transportCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{breakerStateDesc, breakerOpenedDesc, inFlightDesc, attemptsDesc, attemptFailuresDesc, retriesDesc, rejectedDesc, throttledDesc} {
+		ch <- desc
+	}
+}
+
+func (c *transportCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, t := range c.transports {
+		stats := t.Stats()
+
+		for _, state := range []string{outbound.StateClosed, outbound.StateOpen, outbound.StateHalfOpen} {
+			value := 0.0
+			if stats.State == state {
+				value = 1
+			}
+			ch <- prometheus.MustNewConstMetric(breakerStateDesc, prometheus.GaugeValue, value, stats.Upstream, state)
+		}
+
+		ch <- prometheus.MustNewConstMetric(breakerOpenedDesc, prometheus.CounterValue, float64(stats.Opened), stats.Upstream)
+		ch <- prometheus.MustNewConstMetric(inFlightDesc, prometheus.GaugeValue, float64(stats.InFlight), stats.Upstream)
+		ch <- prometheus.MustNewConstMetric(attemptsDesc, prometheus.CounterValue, float64(stats.Requests), stats.Upstream)
+		ch <- prometheus.MustNewConstMetric(attemptFailuresDesc, prometheus.CounterValue, float64(stats.Failures), stats.Upstream)
+		ch <- prometheus.MustNewConstMetric(retriesDesc, prometheus.CounterValue, float64(stats.Retries), stats.Upstream)
+		ch <- prometheus.MustNewConstMetric(rejectedDesc, prometheus.CounterValue, float64(stats.Rejected), stats.Upstream)
+		ch <- prometheus.MustNewConstMetric(throttledDesc, prometheus.CounterValue, float64(stats.Throttled), stats.Upstream)
+	}
+}
@@ -0,0 +1,24 @@
+-- +goose Up
+CREATE TABLE api_clients (
+	id SERIAL PRIMARY KEY,
+	client_id VARCHAR(255) NOT NULL UNIQUE,
+	name VARCHAR(255) NOT NULL,
+	channel VARCHAR(50) NOT NULL,
+	api_key_hash CHAR(64) UNIQUE,
+	hmac_secret VARCHAR(255),
+	active BOOLEAN NOT NULL DEFAULT TRUE,
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE api_client_nonces (
+	client_id VARCHAR(255) NOT NULL REFERENCES api_clients(client_id) ON DELETE CASCADE,
+	nonce VARCHAR(255) NOT NULL,
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (client_id, nonce)
+);
+
+CREATE INDEX api_client_nonces_created_at_idx ON api_client_nonces (created_at);
+
+-- +goose Down
+DROP TABLE IF EXISTS api_client_nonces;
+DROP TABLE IF EXISTS api_clients;
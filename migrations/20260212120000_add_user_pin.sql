@@ -0,0 +1,16 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS users (
+	user_id VARCHAR(255) PRIMARY KEY,
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE users
+	ADD COLUMN IF NOT EXISTS pin_hash VARCHAR(255),
+	ADD COLUMN IF NOT EXISTS failed_pin_attempts INTEGER NOT NULL DEFAULT 0,
+	ADD COLUMN IF NOT EXISTS status VARCHAR(20) NOT NULL DEFAULT 'ACTIVE';
+
+-- +goose Down
+ALTER TABLE users
+	DROP COLUMN IF EXISTS status,
+	DROP COLUMN IF EXISTS failed_pin_attempts,
+	DROP COLUMN IF EXISTS pin_hash;
@@ -0,0 +1,18 @@
+-- +goose Up
+-- Links users to the accounts they may act on. OWNER and JOINT holders have
+-- full access; DELEGATE rows grant a user access to someone else's account
+-- (e.g. a business account) and may be limited to viewing or time-boxed.
+CREATE TABLE account_users (
+	account_id INTEGER NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+	user_id VARCHAR(255) NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+	role VARCHAR(20) NOT NULL CHECK (role IN ('OWNER', 'JOINT', 'DELEGATE')),
+	can_debit BOOLEAN NOT NULL DEFAULT TRUE,
+	expires_at TIMESTAMP WITH TIME ZONE,
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (account_id, user_id)
+);
+
+CREATE INDEX account_users_user_id_idx ON account_users (user_id);
+
+-- +goose Down
+DROP TABLE IF EXISTS account_users;
@@ -0,0 +1,25 @@
+-- +goose Up
+CREATE TABLE payments (
+	id SERIAL PRIMARY KEY,
+	request_id VARCHAR(255) NOT NULL UNIQUE,
+	user_id VARCHAR(255) NOT NULL,
+	control_no VARCHAR(255) NOT NULL,
+	vd_response_id VARCHAR(255) NOT NULL,
+	debit_account VARCHAR(255) NOT NULL,
+	credit_account VARCHAR(255) NOT NULL,
+	amount NUMERIC(20, 2) NOT NULL,
+	currency VARCHAR(10) NOT NULL,
+	state VARCHAR(20) NOT NULL CHECK (state IN ('PENDING', 'SUBMITTED', 'SUCCEEDED', 'FAILED', 'UNKNOWN')),
+	gateway_status_id VARCHAR(50) NOT NULL DEFAULT '',
+	gateway_status_message VARCHAR(500) NOT NULL DEFAULT '',
+	gateway_ref_id VARCHAR(255) NOT NULL DEFAULT '',
+	receipt_no VARCHAR(255) NOT NULL DEFAULT '',
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+	updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX payments_state_idx ON payments (state);
+CREATE INDEX payments_control_no_idx ON payments (control_no);
+
+-- +goose Down
+DROP TABLE IF EXISTS payments;
@@ -0,0 +1,12 @@
+-- +goose Up
+CREATE TABLE idempotency_keys (
+	idempotency_key VARCHAR(255) PRIMARY KEY,
+	request_id VARCHAR(255) NOT NULL,
+	request_path VARCHAR(255) NOT NULL,
+	request_hash CHAR(64) NOT NULL,
+	user_id VARCHAR(255) NOT NULL,
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS idempotency_keys;
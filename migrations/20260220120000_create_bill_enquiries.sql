@@ -0,0 +1,18 @@
+-- +goose Up
+CREATE TABLE bill_enquiries (
+	vd_response_id VARCHAR(255) PRIMARY KEY,
+	control_no VARCHAR(255) NOT NULL,
+	amount VARCHAR(50) NOT NULL,
+	min_amount VARCHAR(50) NOT NULL,
+	currency VARCHAR(10) NOT NULL,
+	payment_option VARCHAR(50) NOT NULL,
+	payment_plan VARCHAR(50) NOT NULL,
+	credit_account VARCHAR(255) NOT NULL,
+	bill_expire_date VARCHAR(50) NOT NULL,
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX bill_enquiries_control_no_idx ON bill_enquiries (control_no);
+
+-- +goose Down
+DROP TABLE IF EXISTS bill_enquiries;
@@ -0,0 +1,11 @@
+-- +goose Up
+ALTER TABLE request_logs
+	ADD COLUMN IF NOT EXISTS trace_id VARCHAR(32) NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS request_logs_trace_id_idx ON request_logs (trace_id);
+
+-- +goose Down
+DROP INDEX IF EXISTS request_logs_trace_id_idx;
+
+ALTER TABLE request_logs
+	DROP COLUMN IF EXISTS trace_id;
@@ -0,0 +1,14 @@
+-- +goose Up
+CREATE TABLE activity_logs (
+	id BIGSERIAL PRIMARY KEY,
+	user_id VARCHAR(255) NOT NULL,
+	request_id VARCHAR(255) NOT NULL DEFAULT '',
+	trace_id VARCHAR(32) NOT NULL DEFAULT '',
+	message TEXT NOT NULL,
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX activity_logs_user_id_created_at_idx ON activity_logs (user_id, created_at);
+
+-- +goose Down
+DROP TABLE IF EXISTS activity_logs;
@@ -0,0 +1,16 @@
+-- +goose Up
+ALTER TABLE payments
+	ADD COLUMN IF NOT EXISTS poll_attempts INTEGER NOT NULL DEFAULT 0,
+	ADD COLUMN IF NOT EXISTS next_poll_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+	ADD COLUMN IF NOT EXISTS needs_review BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE INDEX IF NOT EXISTS payments_next_poll_at_idx ON payments (next_poll_at)
+	WHERE state = 'UNKNOWN' AND NOT needs_review;
+
+-- +goose Down
+DROP INDEX IF EXISTS payments_next_poll_at_idx;
+
+ALTER TABLE payments
+	DROP COLUMN IF EXISTS needs_review,
+	DROP COLUMN IF EXISTS next_poll_at,
+	DROP COLUMN IF EXISTS poll_attempts;
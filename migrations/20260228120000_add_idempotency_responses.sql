@@ -0,0 +1,11 @@
+-- +goose Up
+ALTER TABLE idempotency_keys
+	ADD COLUMN IF NOT EXISTS response_status INTEGER NOT NULL DEFAULT 0,
+	ADD COLUMN IF NOT EXISTS response_body BYTEA,
+	ADD COLUMN IF NOT EXISTS completed_at TIMESTAMP WITH TIME ZONE;
+
+-- +goose Down
+ALTER TABLE idempotency_keys
+	DROP COLUMN IF EXISTS completed_at,
+	DROP COLUMN IF EXISTS response_body,
+	DROP COLUMN IF EXISTS response_status;
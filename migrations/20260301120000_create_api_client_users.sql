@@ -0,0 +1,16 @@
+-- +goose Up
+ALTER TABLE api_clients
+	ADD COLUMN IF NOT EXISTS assert_any_user BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE api_client_users (
+	client_id VARCHAR(255) NOT NULL REFERENCES api_clients(client_id) ON DELETE CASCADE,
+	user_id VARCHAR(255) NOT NULL,
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (client_id, user_id)
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS api_client_users;
+
+ALTER TABLE api_clients
+	DROP COLUMN IF EXISTS assert_any_user;
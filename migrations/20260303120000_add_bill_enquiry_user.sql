@@ -0,0 +1,11 @@
+-- +goose Up
+ALTER TABLE bill_enquiries
+	ADD COLUMN IF NOT EXISTS user_id VARCHAR(255) NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS bill_enquiries_created_at_idx ON bill_enquiries (created_at);
+
+-- +goose Down
+DROP INDEX IF EXISTS bill_enquiries_created_at_idx;
+
+ALTER TABLE bill_enquiries
+	DROP COLUMN IF EXISTS user_id;
@@ -1,8 +1,11 @@
 package model
 
+import "encoding/json"
+
 type ApiRequestEnquire struct {
-	ControlNo string `json:"control_number"`
-	RequestID string `json:"request_id"`
+	ControlNo     string `json:"control_number" validate:"required,digits,len=12"`
+	RequestID     string `json:"request_id" validate:"max=64"`
+	AccountNumber string `json:"account_number" validate:"digits,min=8,max=20"`
 }
 
 type EnquireRequest struct {
@@ -34,9 +37,9 @@ type ApiResponse struct {
 	SpCode          string `json:"spCode"`
 	SpName          string `json:"spName"`
 	CreditAccount   string `json:"creditAccount"`
-	Amount          string `json:"amount"`
+	Amount          Money  `json:"amount"`
 	Currency        string `json:"currency"`
-	MinAmount       string `json:"minAmount"`
+	MinAmount       Money  `json:"minAmount"`
 	PaymentPlan     string `json:"paymentPlan"`
 	PaymentOption   string `json:"paymentOption"`
 	BillExpireDate  string `json:"billExpireDate"`
@@ -53,7 +56,7 @@ type PaymentRequest struct {
 	Email          string `json:"email"`
 	DebitAccount   string `json:"debitAccount"`
 	CreditAccount  string `json:"creditAccount"`
-	Amount         string `json:"amount"`
+	Amount         Money  `json:"amount"`
 	Currency       string `json:"currency"`
 	PaymentMethod  string `json:"paymentMethod"`
 	PSPReferenceID string `json:"pspReferenceId"`
@@ -62,20 +65,21 @@ type PaymentRequest struct {
 }
 
 type PaymentRequestApi struct {
-	ControlNo      string `json:"controlNo"`
-	VDResponseID   string `json:"vdResponseId"`
-	PayerName      string `json:"payerName"`
-	MobileNo       string `json:"mobileNo"`
-	Email          string `json:"email"`
-	DebitAccount   string `json:"debitAccount"`
-	CreditAccount  string `json:"creditAccount"`
-	Amount         string `json:"amount"`
-	Currency       string `json:"currency"`
+	ControlNo      string `json:"controlNo" validate:"required,digits,len=12"`
+	VDResponseID   string `json:"vdResponseId" validate:"required,max=64"`
+	PayerName      string `json:"payerName" validate:"required,max=100"`
+	MobileNo       string `json:"mobileNo" validate:"required,msisdn"`
+	Email          string `json:"email" validate:"email,max=100"`
+	DebitAccount   string `json:"debitAccount" validate:"required,digits,min=8,max=20"`
+	CreditAccount  string `json:"creditAccount" validate:"max=34"`
+	Amount         Money  `json:"amount" validate:"required,amount"`
+	Currency       string `json:"currency" validate:"required,currency"`
 	PaymentMethod  string `json:"paymentMethod"`
-	PSPReferenceID string `json:"pspReferenceId"`
+	PSPReferenceID string `json:"pspReferenceId" validate:"max=64"`
 	CBFlag         string `json:"cbFlag"`
 	CLFlag         string `json:"clFlag"`
-	RequestID      string `json:"request_id"`
+	RequestID      string `json:"request_id" validate:"max=64"`
+	Pin            string `json:"pin" validate:"required,max=32"`
 }
 
 type ControlNumberPaymentResponse struct {
@@ -84,15 +88,40 @@ type ControlNumberPaymentResponse struct {
 	Data          ControlNumberData `json:"data"`
 }
 
+// ControlNumberData is the payment detail the gateway returns and the service
+// passes on. Amount stays a JSON number, as it was before amounts became Money,
+// so clients reading the payment response keep working; set it with
+// Money.Number.
 type ControlNumberData struct {
-	ControlNo       string `json:"controlNo"`
-	RequestId       string `json:"requestId"`
-	ApiResponseId   int    `json:"apiResponseId"`
-	ApiResponseDate string `json:"apiResponseDate"`
-	DebitAccount    string `json:"debitAccount"`
-	CreditAccount   string `json:"creditAccount"`
-	Amount          int    `json:"amount"`
-	Currency        string `json:"currency"`
-	GatewayRefId    string `json:"gatewayRefId,omitempty"`
-	ReceiptNo       string `json:"receiptNo,omitempty"`
+	ControlNo       string      `json:"controlNo"`
+	RequestId       string      `json:"requestId"`
+	ApiResponseId   int         `json:"apiResponseId"`
+	ApiResponseDate string      `json:"apiResponseDate"`
+	DebitAccount    string      `json:"debitAccount"`
+	CreditAccount   string      `json:"creditAccount"`
+	Amount          json.Number `json:"amount"`
+	Currency        string      `json:"currency"`
+	GatewayRefId    string      `json:"gatewayRefId,omitempty"`
+	ReceiptNo       string      `json:"receiptNo,omitempty"`
+}
+
+type PaymentStatusRequest struct {
+	RequestID    string `json:"requestId"`
+	ChannelCode  string `json:"channelCode"`
+	SecurityCode string `json:"securityCode"`
+}
+
+type PaymentStatusResponse struct {
+	RequestID     string `json:"requestId"`
+	ControlNo     string `json:"controlNo"`
+	State         string `json:"state"`
+	DebitAccount  string `json:"debitAccount,omitempty"`
+	CreditAccount string `json:"creditAccount,omitempty"`
+	Amount        Money  `json:"amount"`
+	Currency      string `json:"currency,omitempty"`
+	GatewayRefId  string `json:"gatewayRefId,omitempty"`
+	ReceiptNo     string `json:"receiptNo,omitempty"`
+	StatusMessage string `json:"statusMessage,omitempty"`
+	CreatedAt     string `json:"createdAt,omitempty"`
+	UpdatedAt     string `json:"updatedAt,omitempty"`
 }
@@ -1,6 +1,9 @@
 package model
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 type AccountVerificationRequest struct {
 	AccountNumber   string `json:"account_number"`
@@ -27,15 +30,18 @@ type AccountVerificationRespond struct {
 }
 
 type AccountBalanceRequest struct {
-	AccountNumber string `json:"accountNumber"`
-	RequestID     string `json:"requestId"`
+	AccountNumber string `json:"accountNumber" validate:"required,digits,min=8,max=20"`
+	RequestID     string `json:"requestId" validate:"required,max=64"`
 }
 
 type AccountBalanceResponse struct {
-	AccountBalance float64 `json:"accountBalance"`
-	Currency       string  `json:"currency"`
-	AccountNumber  string  `json:"accountNumber"`
-	AccountName    string  `json:"accountName"`
+	// AccountBalance stays a JSON number, as it was before amounts became
+	// Money, so existing clients keep working.
+	AccountBalance   json.Number `json:"accountBalance"`
+	Currency         string      `json:"currency"`
+	FormattedBalance string      `json:"formattedBalance"`
+	AccountNumber    string      `json:"accountNumber"`
+	AccountName      string      `json:"accountName"`
 }
 
 type ErrorResponse struct {
@@ -77,7 +83,7 @@ type TransactionModel struct {
 	UserID                     string    `json:"userId"`
 	AccountID                  int       `json:"accountId"`
 	TransactionType            string    `json:"transactionType"`
-	Amount                     float64   `json:"amount"`
+	Amount                     Money     `json:"amount"`
 	TransactionDate            time.Time `json:"transactionDate"`
 	Description                string    `json:"description"`
 	TransactionTo              string    `json:"transactionTo"`
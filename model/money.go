@@ -0,0 +1,242 @@
+package model
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// moneyScale is the number of minor units in one major unit. Every amount in
+// the service carries two decimal places, so currencies with finer minor units
+// cannot be represented.
+const moneyScale = 100
+
+var (
+	ErrInvalidMoney     = errors.New("invalid money amount")
+	ErrCurrencyMismatch = errors.New("currency mismatch")
+)
+
+// Money is a fixed-point amount in minor units (cents) with an optional ISO
+// 4217 currency code.
+//
+// In JSON only the amount is written, as a string such as "1000.50", since
+// every API and gateway message carries the currency in a separate field.
+// Strings, numbers and null are all accepted when decoding.
+type Money struct {
+	Minor    int64
+	Currency string
+}
+
+// ParseMoney parses a decimal such as "1000.50", "1,000" or "-15.5". More
+// than two decimal places is an error unless the extra digits are zero.
+func ParseMoney(value, currency string) (Money, error) {
+	raw := strings.ReplaceAll(strings.TrimSpace(value), ",", "")
+
+	negative := strings.HasPrefix(raw, "-")
+	raw = strings.TrimPrefix(strings.TrimPrefix(raw, "-"), "+")
+
+	whole, fraction, _ := strings.Cut(raw, ".")
+	fraction = strings.TrimRight(fraction, "0")
+	if whole == "" {
+		whole = "0"
+	}
+	if raw == "" || len(fraction) > 2 || !isDigits(whole) || (fraction != "" && !isDigits(fraction)) {
+		return Money{}, fmt.Errorf("%w: %q", ErrInvalidMoney, value)
+	}
+
+	units, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil || units > math.MaxInt64/moneyScale-1 {
+		return Money{}, fmt.Errorf("%w: %q", ErrInvalidMoney, value)
+	}
+
+	fraction += strings.Repeat("0", 2-len(fraction))
+	cents, _ := strconv.ParseInt(fraction, 10, 64)
+
+	minor := units*moneyScale + cents
+	if negative {
+		minor = -minor
+	}
+
+	return Money{Minor: minor, Currency: currency}, nil
+}
+
+// MustParseMoney is ParseMoney for constants; it panics on invalid input.
+func MustParseMoney(value, currency string) Money {
+	m, err := ParseMoney(value, currency)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// WithCurrency returns m in currency.
+func (m Money) WithCurrency(currency string) Money {
+	m.Currency = currency
+	return m
+}
+
+func (m Money) IsZero() bool {
+	return m.Minor == 0
+}
+
+// Sign returns -1, 0 or 1.
+func (m Money) Sign() int {
+	switch {
+	case m.Minor < 0:
+		return -1
+	case m.Minor > 0:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Add returns m + o. Both must be in the same currency unless either has none.
+func (m Money) Add(o Money) (Money, error) {
+	currency, err := commonCurrency(m, o)
+	if err != nil {
+		return Money{}, err
+	}
+	return Money{Minor: m.Minor + o.Minor, Currency: currency}, nil
+}
+
+// Sub returns m - o. Both must be in the same currency unless either has none.
+func (m Money) Sub(o Money) (Money, error) {
+	currency, err := commonCurrency(m, o)
+	if err != nil {
+		return Money{}, err
+	}
+	return Money{Minor: m.Minor - o.Minor, Currency: currency}, nil
+}
+
+// Cmp compares the amounts of m and o, returning -1, 0 or 1. Currencies are
+// not compared; callers that may mix them must check first.
+func (m Money) Cmp(o Money) int {
+	switch {
+	case m.Minor < o.Minor:
+		return -1
+	case m.Minor > o.Minor:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func (m Money) String() string {
+	sign := ""
+	minor := m.Minor
+	if minor < 0 {
+		sign, minor = "-", -minor
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, minor/moneyScale, minor%moneyScale)
+}
+
+// Number returns the amount as a JSON number such as 1000.50, for response
+// fields that were numeric before Money and must stay so for existing clients.
+func (m Money) Number() json.Number {
+	return json.Number(m.String())
+}
+
+func (m Money) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.String())
+}
+
+// UnmarshalJSON accepts "1000.50", 1000.50, "" and null. The currency is left
+// unchanged.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		m.Minor = 0
+		return nil
+	}
+
+	value := string(data)
+	if len(data) > 0 && data[0] == '"' {
+		if err := json.Unmarshal(data, &value); err != nil {
+			return err
+		}
+		if strings.TrimSpace(value) == "" {
+			m.Minor = 0
+			return nil
+		}
+	}
+
+	parsed, err := ParseMoney(value, m.Currency)
+	if err != nil {
+		// a type error lets encoding/json report the offending field
+		return &json.UnmarshalTypeError{Value: "amount " + string(data), Type: reflect.TypeOf(m).Elem()}
+	}
+
+	*m = parsed
+	return nil
+}
+
+// Value stores the amount as a decimal string, suitable for NUMERIC and text
+// columns.
+func (m Money) Value() (driver.Value, error) {
+	return m.String(), nil
+}
+
+func (m *Money) Scan(src any) error {
+	var value string
+	switch v := src.(type) {
+	case nil:
+		m.Minor = 0
+		return nil
+	case []byte:
+		value = string(v)
+	case string:
+		value = v
+	case int64:
+		value = strconv.FormatInt(v, 10)
+	case float64:
+		value = strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Errorf("%w: cannot scan %T", ErrInvalidMoney, src)
+	}
+
+	if strings.TrimSpace(value) == "" {
+		m.Minor = 0
+		return nil
+	}
+
+	parsed, err := ParseMoney(value, m.Currency)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+func commonCurrency(a, b Money) (string, error) {
+	switch {
+	case a.Currency == "":
+		return b.Currency, nil
+	case b.Currency == "" || strings.EqualFold(a.Currency, b.Currency):
+		return a.Currency, nil
+	default:
+		return "", fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, a.Currency, b.Currency)
+	}
+}
+
+func isDigits(value string) bool {
+	if value == "" {
+		return false
+	}
+	for _, r := range value {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,131 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		value     string
+		wantMinor int64
+		wantErr   bool
+	}{
+		{"1000.50", 100050, false},
+		{"1000", 100000, false},
+		{"1,000", 100000, false},
+		{" 15.5 ", 1550, false},
+		{"-15.5", -1550, false},
+		{"+15.5", 1550, false},
+		{".5", 50, false},
+		{"0.01", 1, false},
+		{"12.340", 1234, false},
+		{"0", 0, false},
+		{"92233720368547758.07", 0, true},
+		{"", 0, true},
+		{"-", 0, true},
+		{"abc", 0, true},
+		{"1.2.3", 0, true},
+		{"1.234", 0, true},
+		{"1e3", 0, true},
+		{"12.3a", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseMoney(tt.value, "TZS")
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidMoney) {
+					t.Fatalf("ParseMoney(%q) error = %v, want %v", tt.value, err, ErrInvalidMoney)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseMoney(%q) error = %v", tt.value, err)
+			}
+			if got.Minor != tt.wantMinor || got.Currency != "TZS" {
+				t.Errorf("ParseMoney(%q) = %+v, want %d TZS", tt.value, got, tt.wantMinor)
+			}
+		})
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	tests := []struct {
+		minor int64
+		want  string
+	}{
+		{100050, "1000.50"},
+		{5, "0.05"},
+		{0, "0.00"},
+		{-1550, "-15.50"},
+	}
+
+	for _, tt := range tests {
+		if got := (Money{Minor: tt.minor}).String(); got != tt.want {
+			t.Errorf("Money{%d}.String() = %q, want %q", tt.minor, got, tt.want)
+		}
+	}
+}
+
+func TestMoneyUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name      string
+		json      string
+		wantMinor int64
+		wantErr   bool
+	}{
+		{"string", `"1000.50"`, 100050, false},
+		{"number", `1000.5`, 100050, false},
+		{"integer", `250`, 25000, false},
+		{"grouped string", `"1,000"`, 100000, false},
+		{"empty string", `""`, 0, false},
+		{"blank string", `"  "`, 0, false},
+		{"null", `null`, 0, false},
+		{"too many decimals", `"1.234"`, 0, true},
+		{"exponent", `1e3`, 0, true},
+		{"not a number", `"abc"`, 0, true},
+		{"bool", `true`, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var payload struct {
+				Amount Money `json:"amount"`
+			}
+			payload.Amount = Money{Minor: 999, Currency: "USD"}
+
+			err := json.Unmarshal([]byte(`{"amount":`+tt.json+`}`), &payload)
+			if tt.wantErr {
+				var typeErr *json.UnmarshalTypeError
+				if !errors.As(err, &typeErr) {
+					t.Fatalf("Unmarshal(%s) error = %v, want *json.UnmarshalTypeError", tt.json, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unmarshal(%s) error = %v", tt.json, err)
+			}
+			if payload.Amount.Minor != tt.wantMinor || payload.Amount.Currency != "USD" {
+				t.Errorf("Unmarshal(%s) = %+v, want %d USD", tt.json, payload.Amount, tt.wantMinor)
+			}
+		})
+	}
+}
+
+func TestMoneyJSONFormats(t *testing.T) {
+	amount := MustParseMoney("1000.5", "TZS")
+
+	got, err := json.Marshal(struct {
+		Amount  Money       `json:"amount"`
+		Balance json.Number `json:"balance"`
+	}{amount, amount.Number()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := `{"amount":"1000.50","balance":1000.50}`; string(got) != want {
+		t.Errorf("Marshal = %s, want %s", got, want)
+	}
+}
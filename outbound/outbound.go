@@ -0,0 +1,426 @@
+// Package outbound wraps the HTTP transport used for calls to upstream
+// services with retries, a circuit breaker and a concurrency limit.
+//
+// Retries are opt-in per request: only requests whose context was marked
+// with Idempotent are retried, so a payment submission is never sent twice.
+package outbound
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/leopardquick/zssf/logging"
+)
+
+var (
+	// ErrCircuitOpen is returned without sending the request while the
+	// upstream's circuit breaker is open.
+	ErrCircuitOpen = errors.New("circuit breaker open")
+	// ErrConcurrencyLimit is returned without sending the request when no
+	// slot for the upstream freed up within AcquireTimeout or before the
+	// request's context ended.
+	ErrConcurrencyLimit = errors.New("upstream concurrency limit reached")
+)
+
+// Breaker states.
+const (
+	StateClosed   = "closed"
+	StateOpen     = "open"
+	StateHalfOpen = "half-open"
+)
+
+// Policy configures one upstream. Zero values disable the feature.
+type Policy struct {
+	// MaxAttempts is the total number of tries for an idempotent request.
+	MaxAttempts int
+	// BaseDelay is the backoff before the first retry; it doubles on every
+	// retry up to MaxDelay and is jittered.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// FailureThreshold consecutive failures open the breaker for OpenFor,
+	// after which one probe request is let through.
+	FailureThreshold int
+	OpenFor          time.Duration
+	// MaxConcurrent caps in-flight requests to the upstream. A request holds
+	// its slot until the response body is closed.
+	MaxConcurrent int
+	// AcquireTimeout is the longest a request waits for a slot before
+	// failing fast; zero waits as long as the request's context allows.
+	AcquireTimeout time.Duration
+}
+
+// Stats is a snapshot of a Transport's counters.
+type Stats struct {
+	Upstream  string `json:"upstream"`
+	State     string `json:"state"`
+	InFlight  int64  `json:"inFlight"`
+	Requests  uint64 `json:"requests"`
+	Failures  uint64 `json:"failures"`
+	Retries   uint64 `json:"retries"`
+	Rejected  uint64 `json:"rejected"`
+	Throttled uint64 `json:"throttled"`
+	Opened    uint64 `json:"opened"`
+}
+
+// Transport is an http.RoundTripper for a single upstream. A failure is a
+// transport error or a 5xx response; everything else counts as success.
+type Transport struct {
+	Name   string
+	Base   http.RoundTripper
+	Policy Policy
+	Logger *slog.Logger
+
+	slots chan struct{}
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	probing  bool
+
+	inFlight  atomic.Int64
+	requests  atomic.Uint64
+	failed    atomic.Uint64
+	retries   atomic.Uint64
+	rejected  atomic.Uint64
+	throttled atomic.Uint64
+	opened    atomic.Uint64
+}
+
+func New(name string, policy Policy, base http.RoundTripper) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	t := &Transport{
+		Name:   name,
+		Base:   base,
+		Policy: policy,
+		Logger: slog.Default(),
+		state:  StateClosed,
+	}
+	if policy.MaxConcurrent > 0 {
+		t.slots = make(chan struct{}, policy.MaxConcurrent)
+	}
+	return t
+}
+
+type idempotentKey struct{}
+
+// Idempotent marks requests made with the returned context as safe to retry.
+func Idempotent(ctx context.Context) context.Context {
+	return context.WithValue(ctx, idempotentKey{}, true)
+}
+
+func isIdempotent(ctx context.Context) bool {
+	v, _ := ctx.Value(idempotentKey{}).(bool)
+	return v
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	attempts := 1
+	if t.Policy.MaxAttempts > 1 && isIdempotent(req.Context()) && (req.Body == nil || req.GetBody != nil) {
+		attempts = t.Policy.MaxAttempts
+	}
+
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 && req.Body != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+
+		response, err := t.try(req)
+		if attempt >= attempts || !retryable(req.Context(), response, err) {
+			return response, err
+		}
+
+		if response != nil {
+			_, _ = io.Copy(io.Discard, response.Body)
+			response.Body.Close()
+		}
+
+		t.retries.Add(1)
+		if err := sleepContext(req.Context(), t.backoff(attempt)); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// try sends req once through the concurrency limit and the breaker. The
+// concurrency slot is handed to the response body and freed when the caller
+// closes it, so the limit also covers responses still being read.
+func (t *Transport) try(req *http.Request) (*http.Response, error) {
+	if err := t.acquire(req.Context()); err != nil {
+		return nil, err
+	}
+
+	response, err := t.send(req)
+	if err != nil || response.Body == nil {
+		t.release()
+		return response, err
+	}
+
+	response.Body = &releasingBody{ReadCloser: response.Body, release: t.release}
+	return response, nil
+}
+
+// send passes req to the breaker and, if it allows, to the upstream.
+func (t *Transport) send(req *http.Request) (*http.Response, error) {
+	if err := t.allow(); err != nil {
+		return nil, err
+	}
+
+	t.requests.Add(1)
+	start := time.Now()
+	response, err := t.Base.RoundTrip(req)
+	t.logAttempt(req, response, err, time.Since(start))
+
+	switch {
+	case err != nil && errors.Is(req.Context().Err(), context.Canceled):
+		// the caller gave up, which says nothing about the upstream; free
+		// the probe slot so the next request can test it instead
+		t.mu.Lock()
+		t.probing = false
+		t.mu.Unlock()
+	case err != nil || response.StatusCode >= http.StatusInternalServerError:
+		t.failed.Add(1)
+		t.record(false)
+	default:
+		t.record(true)
+	}
+
+	return response, err
+}
+
+func (t *Transport) acquire(ctx context.Context) error {
+	if t.slots == nil {
+		t.inFlight.Add(1)
+		return nil
+	}
+
+	if t.Policy.AcquireTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.Policy.AcquireTimeout)
+		defer cancel()
+	}
+
+	select {
+	case t.slots <- struct{}{}:
+		t.inFlight.Add(1)
+		return nil
+	case <-ctx.Done():
+		t.throttled.Add(1)
+		return fmt.Errorf("%w: %s", ErrConcurrencyLimit, t.Name)
+	}
+}
+
+func (t *Transport) release() {
+	t.inFlight.Add(-1)
+	if t.slots != nil {
+		<-t.slots
+	}
+}
+
+// releasingBody frees the request's concurrency slot when the body is closed.
+type releasingBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+// allow reports whether the breaker lets a request through, moving an open
+// breaker to half-open once OpenFor has passed.
+func (t *Transport) allow() error {
+	if t.Policy.FailureThreshold <= 0 {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch t.state {
+	case StateOpen:
+		if time.Since(t.openedAt) < t.Policy.OpenFor {
+			t.rejected.Add(1)
+			return fmt.Errorf("%w: %s", ErrCircuitOpen, t.Name)
+		}
+		t.transition(StateHalfOpen, "open period elapsed")
+		t.probing = true
+		return nil
+	case StateHalfOpen:
+		if t.probing {
+			t.rejected.Add(1)
+			return fmt.Errorf("%w: %s", ErrCircuitOpen, t.Name)
+		}
+		t.probing = true
+		return nil
+	default:
+		return nil
+	}
+}
+
+func (t *Transport) record(success bool) {
+	if t.Policy.FailureThreshold <= 0 {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.state == StateHalfOpen {
+		t.probing = false
+		if success {
+			t.failures = 0
+			t.transition(StateClosed, "probe succeeded")
+		} else {
+			t.openedAt = time.Now()
+			t.transition(StateOpen, "probe failed")
+		}
+		return
+	}
+
+	if success {
+		t.failures = 0
+		return
+	}
+
+	t.failures++
+	if t.state == StateClosed && t.failures >= t.Policy.FailureThreshold {
+		t.openedAt = time.Now()
+		t.transition(StateOpen, fmt.Sprintf("%d consecutive failures", t.failures))
+	}
+}
+
+// transition must be called with mu held.
+func (t *Transport) transition(to, reason string) {
+	from := t.state
+	t.state = to
+	if to == StateOpen {
+		t.opened.Add(1)
+	}
+	if t.Logger != nil {
+		t.Logger.Warn("circuit breaker state changed",
+			logging.KeyUpstream, t.Name,
+			"from", from,
+			"to", to,
+			"reason", reason,
+		)
+	}
+}
+
+// logAttempt records one call to the upstream: at debug level when it got a
+// response below 500, otherwise as a warning.
+func (t *Transport) logAttempt(req *http.Request, response *http.Response, err error, elapsed time.Duration) {
+	if t.Logger == nil {
+		return
+	}
+
+	attrs := []slog.Attr{
+		slog.String(logging.KeyUpstream, t.Name),
+		slog.String("method", req.Method),
+		slog.String("path", req.URL.Path),
+		slog.Float64(logging.KeyLatency, logging.Milliseconds(elapsed)),
+	}
+
+	level := slog.LevelDebug
+	switch {
+	case err != nil:
+		level = slog.LevelWarn
+		attrs = append(attrs, slog.Any(logging.KeyError, err))
+	case response.StatusCode >= http.StatusInternalServerError:
+		level = slog.LevelWarn
+		attrs = append(attrs, slog.Int("status", response.StatusCode))
+	default:
+		attrs = append(attrs, slog.Int("status", response.StatusCode))
+	}
+
+	t.Logger.LogAttrs(req.Context(), level, "upstream request", attrs...)
+}
+
+// backoff returns the delay before retry number attempt: the exponential step
+// capped at MaxDelay, jittered between half and all of it.
+func (t *Transport) backoff(attempt int) time.Duration {
+	delay := t.Policy.BaseDelay
+	for i := 1; i < attempt && (t.Policy.MaxDelay <= 0 || delay < t.Policy.MaxDelay); i++ {
+		delay *= 2
+	}
+	if t.Policy.MaxDelay > 0 && delay > t.Policy.MaxDelay {
+		delay = t.Policy.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+
+	half := delay / 2
+	return half + rand.N(half+1)
+}
+
+// retryable reports whether a failed attempt may be repeated: transport
+// errors, 429 and the 502-504 responses proxies return for an unreachable
+// upstream.
+func retryable(ctx context.Context, response *http.Response, err error) bool {
+	if ctx.Err() != nil || errors.Is(err, ErrConcurrencyLimit) {
+		return false
+	}
+	if err != nil {
+		return !errors.Is(err, ErrCircuitOpen)
+	}
+
+	switch response.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// State returns the breaker state.
+func (t *Transport) State() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.state
+}
+
+func (t *Transport) Stats() Stats {
+	return Stats{
+		Upstream:  t.Name,
+		State:     t.State(),
+		InFlight:  t.inFlight.Load(),
+		Requests:  t.requests.Load(),
+		Failures:  t.failed.Load(),
+		Retries:   t.retries.Load(),
+		Rejected:  t.rejected.Load(),
+		Throttled: t.throttled.Load(),
+		Opened:    t.opened.Load(),
+	}
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,76 @@
+package redact
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Account keeps the last four digits of an account number, e.g.
+// "0123456789" becomes "******6789". Values with four digits or fewer are
+// masked entirely.
+func Account(value string) string {
+	return maskDigits(strings.TrimSpace(value), 4)
+}
+
+// Phone keeps the last three digits of a phone number and its formatting,
+// e.g. "+255 712 345 678" becomes "+*** *** *** 678".
+func Phone(value string) string {
+	return maskDigits(strings.TrimSpace(value), 3)
+}
+
+// Email keeps the first character of the local part and the domain, e.g.
+// "juma@example.com" becomes "j***@example.com". A value without "@" is
+// redacted.
+func Email(value string) string {
+	local, domain, ok := strings.Cut(strings.TrimSpace(value), "@")
+	if !ok || local == "" {
+		return Redacted
+	}
+	_, size := utf8.DecodeRuneInString(local)
+	return local[:size] + "***@" + domain
+}
+
+// Name keeps the first letter of each word of a name, e.g. "Juma Hassan"
+// becomes "J*** H***".
+func Name(value string) string {
+	words := strings.Fields(value)
+	if len(words) == 0 {
+		return value
+	}
+	for i, word := range words {
+		_, size := utf8.DecodeRuneInString(word)
+		words[i] = word[:size] + "***"
+	}
+	return strings.Join(words, " ")
+}
+
+// maskDigits replaces every digit but the last keep with "*", leaving other
+// characters in place. When the value has no more than keep digits all of
+// them are masked.
+func maskDigits(value string, keep int) string {
+	digits := 0
+	for _, r := range value {
+		if r >= '0' && r <= '9' {
+			digits++
+		}
+	}
+	if digits <= keep {
+		keep = 0
+	}
+
+	var b strings.Builder
+	seen := 0
+	for _, r := range value {
+		if r < '0' || r > '9' {
+			b.WriteRune(r)
+			continue
+		}
+		seen++
+		if seen > digits-keep {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('*')
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,358 @@
+// Package redact removes personal data and secrets from request logs before
+// they are stored. A Policy names the headers to blank and the request and
+// response body fields to redact, hash or partially mask.
+package redact
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Redacted replaces values that are not kept in any form.
+const Redacted = "[REDACTED]"
+
+// Rule actions.
+const (
+	ActionRedact      = "redact"       // replace the value with [REDACTED]
+	ActionRemove      = "remove"       // drop the field
+	ActionHash        = "hash"         // replace the value with its keyed SHA-256
+	ActionMaskAccount = "mask_account" // keep the last four digits
+	ActionMaskPhone   = "mask_phone"   // keep the last three digits
+	ActionMaskEmail   = "mask_email"   // keep the first letter and the domain
+	ActionMaskName    = "mask_name"    // keep the first letter of each word
+)
+
+// Rule applies Action to the fields Path selects. Path is a dot-separated
+// list of object keys, compared ignoring case and "_" or "-" separators so
+// "accountNumber" also matches "account_number". "*" matches any key and
+// arrays are descended into element by element, e.g. "data.debitAccount" or
+// "items.*.email". A leading "$." is ignored.
+type Rule struct {
+	Path   string `json:"path" yaml:"path"`
+	Action string `json:"action" yaml:"action"`
+}
+
+// Policy is applied to every request log. A nil *Policy behaves like
+// DefaultPolicy.
+type Policy struct {
+	// Headers are request and response header names whose values are
+	// replaced with [REDACTED].
+	Headers  []string `json:"headers" yaml:"headers"`
+	Request  []Rule   `json:"request" yaml:"request"`
+	Response []Rule   `json:"response" yaml:"response"`
+
+	// HashKey keys the hash action so hashed phone numbers and emails cannot
+	// be reversed by hashing every candidate. Without it plain SHA-256 is
+	// used.
+	HashKey []byte `json:"-" yaml:"-"`
+}
+
+// DefaultPolicy blanks credential headers, drops PINs and security codes and
+// masks account numbers, phone numbers, emails and payer names in both
+// directions.
+func DefaultPolicy() *Policy {
+	return &Policy{
+		Headers: []string{
+			"Authorization",
+			"Proxy-Authorization",
+			"Cookie",
+			"Set-Cookie",
+			"X-Api-Key",
+			"X-Signature",
+		},
+		Request: []Rule{
+			{Path: "pin", Action: ActionRedact},
+			{Path: "securityCode", Action: ActionRedact},
+			{Path: "password", Action: ActionRedact},
+			{Path: "accountNumber", Action: ActionMaskAccount},
+			{Path: "debitAccount", Action: ActionMaskAccount},
+			{Path: "creditAccount", Action: ActionMaskAccount},
+			{Path: "mobileNo", Action: ActionMaskPhone},
+			{Path: "phoneNumber", Action: ActionMaskPhone},
+			{Path: "email", Action: ActionMaskEmail},
+			{Path: "payerName", Action: ActionMaskName},
+			// a body that was not valid JSON is stored as {"raw": "..."} and
+			// cannot be filtered field by field
+			{Path: "raw", Action: ActionRedact},
+		},
+		Response: []Rule{
+			{Path: "data.accountNumber", Action: ActionMaskAccount},
+			{Path: "data.debitAccount", Action: ActionMaskAccount},
+			{Path: "data.creditAccount", Action: ActionMaskAccount},
+			{Path: "data.mobileNo", Action: ActionMaskPhone},
+			{Path: "data.email", Action: ActionMaskEmail},
+			{Path: "data.payerName", Action: ActionMaskName},
+		},
+	}
+}
+
+// LoadPolicy reads a YAML (.yaml/.yml) or JSON (.json) policy. The file
+// replaces DefaultPolicy rather than extending it.
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read redaction policy: %w", err)
+	}
+
+	var policy Policy
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(data, &policy)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &policy)
+	default:
+		return nil, fmt.Errorf("unsupported redaction policy type %q", filepath.Ext(path))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse redaction policy: %w", err)
+	}
+
+	for _, rule := range append(append([]Rule(nil), policy.Request...), policy.Response...) {
+		if strings.TrimPrefix(rule.Path, "$.") == "" {
+			return nil, fmt.Errorf("redaction rule has no path")
+		}
+		switch rule.Action {
+		case ActionRedact, ActionRemove, ActionHash, ActionMaskAccount, ActionMaskPhone, ActionMaskEmail, ActionMaskName:
+		default:
+			return nil, fmt.Errorf("redaction rule %q has unknown action %q", rule.Path, rule.Action)
+		}
+	}
+
+	return &policy, nil
+}
+
+func (p *Policy) orDefault() *Policy {
+	if p == nil {
+		return DefaultPolicy()
+	}
+	return p
+}
+
+// Header redacts a JSON object of header name to values, as stored in
+// request_logs.request_headers and response_headers.
+func (p *Policy) Header(headers []byte) []byte {
+	p = p.orDefault()
+	if len(p.Headers) == 0 {
+		return headers
+	}
+
+	var values map[string][]string
+	if err := json.Unmarshal(headers, &values); err != nil {
+		return headers
+	}
+
+	changed := false
+	for name := range values {
+		for _, denied := range p.Headers {
+			if strings.EqualFold(name, denied) {
+				values[name] = []string{Redacted}
+				changed = true
+				break
+			}
+		}
+	}
+	if !changed {
+		return headers
+	}
+
+	return marshal(values, headers)
+}
+
+// RequestBody applies the Request rules to a JSON body.
+func (p *Policy) RequestBody(body []byte) []byte {
+	p = p.orDefault()
+	return p.body(p.Request, body)
+}
+
+// ResponseBody applies the Response rules to a JSON body.
+func (p *Policy) ResponseBody(body []byte) []byte {
+	p = p.orDefault()
+	return p.body(p.Response, body)
+}
+
+// body returns the original bytes when no rule matched so bodies without
+// personal data are stored exactly as they were sent.
+func (p *Policy) body(rules []Rule, body []byte) []byte {
+	if len(rules) == 0 || len(body) == 0 {
+		return body
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	var document any
+	if err := decoder.Decode(&document); err != nil {
+		return body
+	}
+
+	changed := false
+	for _, rule := range rules {
+		path := strings.Split(strings.TrimPrefix(rule.Path, "$."), ".")
+		var matched bool
+		document, matched = walk(document, path, func(value any) (any, bool) {
+			return p.apply(rule.Action, value)
+		})
+		changed = changed || matched
+	}
+	if !changed {
+		return body
+	}
+
+	return marshal(document, body)
+}
+
+// walk applies fn to every value path selects in node. fn returns the new
+// value and false when the field should be dropped.
+func walk(node any, path []string, fn func(any) (any, bool)) (any, bool) {
+	switch n := node.(type) {
+	case []any:
+		// "*" also stands for the elements, so "items.*.email" matches
+		// an array of objects as well as "items.email" does
+		if path[0] == "*" && len(path) > 1 {
+			path = path[1:]
+		}
+
+		changed := false
+		for i := range n {
+			var matched bool
+			n[i], matched = walk(n[i], path, fn)
+			changed = changed || matched
+		}
+		return n, changed
+	case map[string]any:
+		changed := false
+		for key, value := range n {
+			if path[0] != "*" && !sameKey(key, path[0]) {
+				continue
+			}
+
+			if len(path) > 1 {
+				var matched bool
+				n[key], matched = walk(value, path[1:], fn)
+				changed = changed || matched
+				continue
+			}
+
+			if replaced, keep := fn(value); keep {
+				n[key] = replaced
+			} else {
+				delete(n, key)
+			}
+			changed = true
+		}
+		return n, changed
+	default:
+		return node, false
+	}
+}
+
+func (p *Policy) apply(action string, value any) (any, bool) {
+	if value == nil {
+		return nil, action != ActionRemove
+	}
+
+	switch action {
+	case ActionRemove:
+		return nil, false
+	case ActionHash:
+		return p.hash(value), true
+	case ActionMaskAccount, ActionMaskPhone, ActionMaskEmail, ActionMaskName:
+		return mask(action, value), true
+	default:
+		return Redacted, true
+	}
+}
+
+// hash returns "sha256:" and the hex HMAC-SHA256 of value under HashKey, or
+// its plain SHA-256 without a key. Equal values hash equally, so requests can
+// still be matched on a hashed field.
+func (p *Policy) hash(value any) string {
+	text, ok := scalar(value)
+	if !ok {
+		data, _ := json.Marshal(value)
+		text = string(data)
+	}
+
+	var sum []byte
+	if len(p.HashKey) > 0 {
+		mac := hmac.New(sha256.New, p.HashKey)
+		mac.Write([]byte(text))
+		sum = mac.Sum(nil)
+	} else {
+		digest := sha256.Sum256([]byte(text))
+		sum = digest[:]
+	}
+	return "sha256:" + hex.EncodeToString(sum)
+}
+
+// mask masks a string or number, or every element of an array of them.
+// Anything else is redacted.
+func mask(action string, value any) any {
+	if values, ok := value.([]any); ok {
+		for i := range values {
+			values[i] = mask(action, values[i])
+		}
+		return values
+	}
+
+	text, ok := scalar(value)
+	if !ok {
+		return Redacted
+	}
+
+	switch action {
+	case ActionMaskAccount:
+		return Account(text)
+	case ActionMaskPhone:
+		return Phone(text)
+	case ActionMaskName:
+		return Name(text)
+	default:
+		return Email(text)
+	}
+}
+
+// sameKey compares an object key with a path element ignoring case and the
+// "_" and "-" separators.
+func sameKey(key, element string) bool {
+	return strings.EqualFold(stripSeparators(key), stripSeparators(element))
+}
+
+func stripSeparators(key string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || r == '-' {
+			return -1
+		}
+		return r
+	}, key)
+}
+
+func scalar(value any) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	default:
+		return "", false
+	}
+}
+
+func marshal(value any, fallback []byte) []byte {
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return fallback
+	}
+	return bytes.TrimRight(buffer.Bytes(), "\n")
+}
@@ -0,0 +1,142 @@
+package redact
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+func TestPolicyRequestBody(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"pin", `{"amount":"10","pin":"1234"}`, `{"amount":"10","pin":"[REDACTED]"}`},
+		{"security code", `{"securityCode":"abc"}`, `{"securityCode":"[REDACTED]"}`},
+		{"account number", `{"accountNumber":"0123456789"}`, `{"accountNumber":"******6789"}`},
+		{"snake case key", `{"account_number":"0123456789"}`, `{"account_number":"******6789"}`},
+		{"numeric account", `{"accountNumber":123456789}`, `{"accountNumber":"*****6789"}`},
+		{"short account", `{"debitAccount":"1234"}`, `{"debitAccount":"****"}`},
+		{"phone", `{"mobileNo":"+255 712 345 678"}`, `{"mobileNo":"+*** *** *** 678"}`},
+		{"email", `{"email":"juma@example.com"}`, `{"email":"j***@example.com"}`},
+		{"email without at", `{"email":"juma"}`, `{"email":"[REDACTED]"}`},
+		{"payer name", `{"payerName":"Juma Hassan"}`, `{"payerName":"J*** H***"}`},
+		{"mask of an object", `{"creditAccount":{"number":"0123456789"}}`, `{"creditAccount":"[REDACTED]"}`},
+		{"null stays null", `{"pin":null}`, `{"pin":null}`},
+		{"raw body", `{"raw":"pin=1234"}`, `{"raw":"[REDACTED]"}`},
+		{"nested field untouched", `{"data": {"pin": "1234"}}`, `{"data": {"pin": "1234"}}`},
+		{"no match keeps bytes", `{ "controlNo" : "991234567890" }`, `{ "controlNo" : "991234567890" }`},
+		{"not json", `pin=1234`, `pin=1234`},
+		{"empty", ``, ``},
+	}
+
+	policy := DefaultPolicy()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(policy.RequestBody([]byte(tt.body))); got != tt.want {
+				t.Errorf("RequestBody(%s) = %s, want %s", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyResponseBody(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{
+			"data fields",
+			`{"data":{"debitAccount":"0123456789","payerName":"Juma"},"statusCode":200}`,
+			`{"data":{"debitAccount":"******6789","payerName":"J***"},"statusCode":200}`,
+		},
+		{
+			"data array",
+			`{"data":[{"accountNumber":"0123456789"},{"accountNumber":"9876543210"}]}`,
+			`{"data":[{"accountNumber":"******6789"},{"accountNumber":"******3210"}]}`,
+		},
+		{
+			"snake case data",
+			`{"data":{"mobile_no":"0712345678"}}`,
+			`{"data":{"mobile_no":"*******678"}}`,
+		},
+		{
+			"top level untouched",
+			`{"accountNumber":"0123456789"}`,
+			`{"accountNumber":"0123456789"}`,
+		},
+		{
+			"html is not escaped",
+			`{"data":{"email":"a@b.c","note":"<b>&</b>"}}`,
+			`{"data":{"email":"a***@b.c","note":"<b>&</b>"}}`,
+		},
+	}
+
+	policy := DefaultPolicy()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(policy.ResponseBody([]byte(tt.body))); got != tt.want {
+				t.Errorf("ResponseBody(%s) = %s, want %s", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyCustomRules(t *testing.T) {
+	key := []byte("hash-key")
+	policy := &Policy{
+		Request: []Rule{
+			{Path: "$.token", Action: ActionRemove},
+			{Path: "items.*.email", Action: ActionMaskEmail},
+			{Path: "phone", Action: ActionHash},
+			{Path: "tags", Action: ActionMaskPhone},
+		},
+		HashKey: key,
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("0712345678"))
+	hashed := "sha256:" + hex.EncodeToString(mac.Sum(nil))
+
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"remove", `{"id":1,"token":"secret"}`, `{"id":1}`},
+		{"wildcard", `{"items":[{"email":"a@b.c"},{"email":"x@y.z"}]}`, `{"items":[{"email":"a***@b.c"},{"email":"x***@y.z"}]}`},
+		{"wildcard object", `{"items":{"a":{"email":"a@b.c"}}}`, `{"items":{"a":{"email":"a***@b.c"}}}`},
+		{"keyed hash", `{"phone":"0712345678"}`, `{"phone":"` + hashed + `"}`},
+		{"mask array", `{"tags":["0712345678","0787654321"]}`, `{"tags":["*******678","*******321"]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(policy.RequestBody([]byte(tt.body))); got != tt.want {
+				t.Errorf("RequestBody(%s) = %s, want %s", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyHashWithoutKey(t *testing.T) {
+	policy := &Policy{Response: []Rule{{Path: "data.reference", Action: ActionHash}}}
+
+	sum := sha256.Sum256([]byte("REF-1"))
+	want := `{"data":{"reference":"sha256:` + hex.EncodeToString(sum[:]) + `"}}`
+	if got := string(policy.ResponseBody([]byte(`{"data":{"reference":"REF-1"}}`))); got != want {
+		t.Errorf("ResponseBody = %s, want %s", got, want)
+	}
+}
+
+func TestNilPolicyUsesDefault(t *testing.T) {
+	var policy *Policy
+
+	got := string(policy.RequestBody([]byte(`{"pin":"1234"}`)))
+	if want := `{"pin":"[REDACTED]"}`; got != want {
+		t.Errorf("RequestBody = %s, want %s", got, want)
+	}
+}
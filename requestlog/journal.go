@@ -0,0 +1,144 @@
+package requestlog
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/leopardquick/zssf/store"
+)
+
+const journalFile = "request_logs.journal"
+
+// journal holds request logs that could not be written to the database, one
+// JSON object per line. The logs are already redacted, but the file is still
+// created readable by the service user only.
+type journal struct {
+	path string
+}
+
+func openJournal(dir string) (*journal, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create request log journal directory: %w", err)
+	}
+	return &journal{path: filepath.Join(dir, journalFile)}, nil
+}
+
+// append adds logs to the journal and syncs it to disk.
+func (j *journal) append(logs []store.RequestLog) error {
+	file, err := os.OpenFile(j.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+
+	if err := writeLogs(file, logs); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// load reads every log in the journal. A line that does not decode, such as
+// one cut short by a crash mid-write, is skipped and counted in skipped.
+func (j *journal) load() (logs []store.RequestLog, skipped int, err error) {
+	skipped, err = j.scan(func(log store.RequestLog) {
+		logs = append(logs, log)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return logs, skipped, nil
+}
+
+// find returns the last log in the journal with requestID, reading the file
+// one line at a time so a large journal is not held in memory.
+func (j *journal) find(requestID string) (found store.RequestLog, ok bool, err error) {
+	_, err = j.scan(func(log store.RequestLog) {
+		if log.RequestID == requestID {
+			found, ok = log, true
+		}
+	})
+	return found, ok, err
+}
+
+// scan calls fn for every log in the journal in order, counting the lines
+// that do not decode.
+func (j *journal) scan(fn func(store.RequestLog)) (skipped int, err error) {
+	file, err := os.Open(j.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			var log store.RequestLog
+			if json.Unmarshal(line, &log) != nil || log.RequestID == "" {
+				skipped++
+			} else {
+				fn(log)
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return skipped, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// rewrite replaces the journal with logs, removing it when logs is empty.
+// The new file is renamed into place so a crash leaves either the old or the
+// new journal.
+func (j *journal) rewrite(logs []store.RequestLog) error {
+	if len(logs) == 0 {
+		if err := os.Remove(j.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(j.path), journalFile+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	if err := writeLogs(temp, logs); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Sync(); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), j.path)
+}
+
+func writeLogs(w io.Writer, logs []store.RequestLog) error {
+	buffered := bufio.NewWriter(w)
+	encoder := json.NewEncoder(buffered)
+	for _, log := range logs {
+		if err := encoder.Encode(log); err != nil {
+			return err
+		}
+	}
+	return buffered.Flush()
+}
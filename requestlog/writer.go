@@ -0,0 +1,419 @@
+// Package requestlog takes request log inserts off the request path. Writer
+// implements store.RequestLogStore by queueing logs in memory and inserting
+// them in batches; batches the database cannot take are kept in an on-disk
+// journal and replayed once it recovers.
+package requestlog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/leopardquick/zssf/logging"
+	"github.com/leopardquick/zssf/store"
+)
+
+const (
+	defaultQueueSize      = 1024
+	defaultBatchSize      = 100
+	defaultFlushInterval  = 200 * time.Millisecond
+	defaultReplayInterval = 30 * time.Second
+	writeTimeout          = 10 * time.Second
+)
+
+// BatchStore is the store a Writer flushes to. *store.SQLRequestLogStore
+// implements it.
+type BatchStore interface {
+	store.RequestLogStore
+	CreateBatch(ctx context.Context, logs []store.RequestLog) error
+}
+
+// Options tunes a Writer. Zero values use the defaults.
+type Options struct {
+	// QueueSize bounds the logs waiting for a batch. When the queue is full
+	// Create inserts synchronously, slowing the request down rather than
+	// losing its log.
+	QueueSize int
+	// BatchSize logs are written per INSERT.
+	BatchSize int
+	// FlushInterval is the longest a log waits for a batch to fill.
+	FlushInterval time.Duration
+	// JournalDir is where batches that failed are kept until they can be
+	// written. Without it they are logged and dropped.
+	JournalDir string
+	// ReplayInterval is how often the journal is retried.
+	ReplayInterval time.Duration
+	Logger         *slog.Logger
+}
+
+// Stats is a snapshot of a Writer's queue and counters.
+type Stats struct {
+	Queued      int
+	Capacity    int
+	Pending     int
+	Journaled   int
+	Written     uint64
+	Synchronous uint64
+	Rejected    uint64
+	Lost        uint64
+}
+
+// Writer is an asynchronous store.RequestLogStore. Logs waiting for a batch
+// are kept in memory and journaled logs on disk, with only their request IDs
+// in memory, so GetByRequestID finds a log as soon as Create has accepted it
+// and a duplicate of one not yet written is refused straight away.
+type Writer struct {
+	store          BatchStore
+	batchSize      int
+	flushInterval  time.Duration
+	replayInterval time.Duration
+	journal        *journal
+	logger         *slog.Logger
+
+	mu        sync.Mutex
+	closed    bool
+	pending   map[string]store.RequestLog
+	journaled map[string]struct{}
+	queue     chan store.RequestLog
+	done      chan struct{}
+
+	written     atomic.Uint64
+	synchronous atomic.Uint64
+	rejected    atomic.Uint64
+	lost        atomic.Uint64
+}
+
+// NewWriter starts a Writer for s, loading logs left in the journal by a
+// previous run. Close must be called to flush the queue.
+func NewWriter(s BatchStore, opts Options) (*Writer, error) {
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = defaultQueueSize
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultBatchSize
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = defaultFlushInterval
+	}
+	if opts.ReplayInterval <= 0 {
+		opts.ReplayInterval = defaultReplayInterval
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+
+	w := &Writer{
+		store:          s,
+		batchSize:      opts.BatchSize,
+		flushInterval:  opts.FlushInterval,
+		replayInterval: opts.ReplayInterval,
+		logger:         opts.Logger,
+		pending:        map[string]store.RequestLog{},
+		journaled:      map[string]struct{}{},
+		queue:          make(chan store.RequestLog, opts.QueueSize),
+		done:           make(chan struct{}),
+	}
+
+	if opts.JournalDir != "" {
+		journal, err := openJournal(opts.JournalDir)
+		if err != nil {
+			return nil, err
+		}
+
+		logs, skipped, err := journal.load()
+		if err != nil {
+			return nil, fmt.Errorf("read request log journal: %w", err)
+		}
+		if skipped > 0 {
+			// drop the unreadable lines so later appends start on a new line
+			w.logger.Error("skipped unreadable request log journal entries", "count", skipped)
+			if err := journal.rewrite(logs); err != nil {
+				return nil, fmt.Errorf("rewrite request log journal: %w", err)
+			}
+		}
+
+		w.journal = journal
+		for _, log := range logs {
+			w.journaled[log.RequestID] = struct{}{}
+		}
+	}
+
+	go w.run()
+	return w, nil
+}
+
+// Create queues log for the next batch. A request ID that is still queued or
+// journaled gets store.ErrRequestLogAlreadyExists; one that only collides with
+// a row already in the database is dropped when it is written.
+func (w *Writer) Create(ctx context.Context, log store.RequestLog) error {
+	if log.CreatedAt.IsZero() {
+		log.CreatedAt = time.Now()
+	}
+
+	w.mu.Lock()
+	if w.inFlight(log.RequestID) {
+		w.mu.Unlock()
+		return store.ErrRequestLogAlreadyExists
+	}
+	if !w.closed {
+		select {
+		case w.queue <- log:
+			w.pending[log.RequestID] = log
+			w.mu.Unlock()
+			return nil
+		default:
+		}
+	}
+	w.mu.Unlock()
+
+	w.synchronous.Add(1)
+	return w.store.CreateBatch(ctx, []store.RequestLog{log})
+}
+
+// GetByRequestID returns a queued log from memory, a journaled one from the
+// journal, and anything else from the store.
+func (w *Writer) GetByRequestID(ctx context.Context, requestID string) (store.RequestLog, error) {
+	w.mu.Lock()
+	log, queued := w.pending[requestID]
+	_, journaled := w.journaled[requestID]
+	w.mu.Unlock()
+
+	if queued {
+		return log, nil
+	}
+	if journaled {
+		log, found, err := w.journal.find(requestID)
+		if err != nil {
+			return store.RequestLog{}, fmt.Errorf("read request log journal: %w", err)
+		}
+		// a replay may have written it since the journal was checked
+		if found {
+			return log, nil
+		}
+	}
+
+	return w.store.GetByRequestID(ctx, requestID)
+}
+
+// inFlight reports whether requestID is queued or journaled. w.mu must be
+// held.
+func (w *Writer) inFlight(requestID string) bool {
+	if _, ok := w.pending[requestID]; ok {
+		return true
+	}
+	_, ok := w.journaled[requestID]
+	return ok
+}
+
+// Close stops queueing, so later Creates insert synchronously, and waits
+// until the queue has been written, or journaled, or ctx ends.
+func (w *Writer) Close(ctx context.Context) error {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+	w.mu.Unlock()
+
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (w *Writer) Stats() Stats {
+	w.mu.Lock()
+	pending, journaled := len(w.pending), len(w.journaled)
+	w.mu.Unlock()
+
+	return Stats{
+		Queued:      len(w.queue),
+		Capacity:    cap(w.queue),
+		Pending:     pending,
+		Journaled:   journaled,
+		Written:     w.written.Load(),
+		Synchronous: w.synchronous.Load(),
+		Rejected:    w.rejected.Load(),
+		Lost:        w.lost.Load(),
+	}
+}
+
+// run is the only goroutine that writes to the store or the journal.
+func (w *Writer) run() {
+	defer close(w.done)
+
+	flush := time.NewTicker(w.flushInterval)
+	defer flush.Stop()
+	replay := time.NewTicker(w.replayInterval)
+	defer replay.Stop()
+
+	batch := make([]store.RequestLog, 0, w.batchSize)
+	for {
+		select {
+		case log, ok := <-w.queue:
+			if !ok {
+				w.write(batch)
+				w.replay()
+				return
+			}
+			batch = append(batch, log)
+			if len(batch) >= w.batchSize {
+				w.write(batch)
+				batch = batch[:0]
+			}
+		case <-flush.C:
+			w.write(batch)
+			batch = batch[:0]
+		case <-replay.C:
+			w.replay()
+		}
+	}
+}
+
+// write inserts batch, journaling whatever the database could not take.
+// While the journal holds entries the database is presumed down and batches
+// go straight to the journal; replay finds out when it is back.
+func (w *Writer) write(batch []store.RequestLog) {
+	if len(batch) == 0 {
+		return
+	}
+
+	w.mu.Lock()
+	journaled := len(w.journaled)
+	w.mu.Unlock()
+
+	unwritten, err := batch, error(nil)
+	if w.journal == nil || journaled == 0 {
+		unwritten, err = w.insert(batch)
+	}
+	if len(unwritten) == 0 {
+		return
+	}
+
+	if w.journal == nil {
+		w.drop(unwritten, err)
+		return
+	}
+
+	if journalErr := w.journal.append(unwritten); journalErr != nil {
+		w.drop(unwritten, errors.Join(err, journalErr))
+		return
+	}
+
+	// moved in one step so the request IDs stay visible to Create
+	w.mu.Lock()
+	for _, log := range unwritten {
+		delete(w.pending, log.RequestID)
+		w.journaled[log.RequestID] = struct{}{}
+	}
+	w.mu.Unlock()
+	if err != nil {
+		w.logger.Warn("request logs journaled", "count", len(unwritten), logging.KeyError, err)
+	}
+}
+
+// replay retries the journal in batches, keeping what still fails.
+func (w *Writer) replay() {
+	w.mu.Lock()
+	journaled := len(w.journaled)
+	w.mu.Unlock()
+	if w.journal == nil || journaled == 0 {
+		return
+	}
+
+	logs, _, err := w.journal.load()
+	if err != nil {
+		w.logger.Error("error reading request log journal", logging.KeyError, err)
+		return
+	}
+
+	var remaining []store.RequestLog
+	for start := 0; start < len(logs); start += w.batchSize {
+		end := min(start+w.batchSize, len(logs))
+		unwritten, err := w.insert(logs[start:end])
+		if len(unwritten) > 0 {
+			remaining = append(append(remaining, unwritten...), logs[end:]...)
+			w.logger.Debug("request log journal replay deferred", "count", len(remaining), logging.KeyError, err)
+			break
+		}
+	}
+
+	if err := w.journal.rewrite(remaining); err != nil {
+		// the journal still holds every entry; written ones are dropped
+		// as duplicates when it is replayed again
+		w.logger.Error("error rewriting request log journal", logging.KeyError, err)
+		return
+	}
+
+	w.mu.Lock()
+	w.journaled = make(map[string]struct{}, len(remaining))
+	for _, log := range remaining {
+		w.journaled[log.RequestID] = struct{}{}
+	}
+	w.mu.Unlock()
+
+	if len(remaining) < len(logs) {
+		w.logger.Info("request log journal replayed", "count", len(logs)-len(remaining), "remaining", len(remaining))
+	}
+}
+
+// insert writes batch and returns the logs that were not written because
+// the database is unavailable, with the error. Logs the database refuses are
+// dropped: when a batch is refused its rows are retried one at a time to
+// find them.
+func (w *Writer) insert(batch []store.RequestLog) ([]store.RequestLog, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), writeTimeout)
+	defer cancel()
+
+	err := w.store.CreateBatch(ctx, batch)
+	switch {
+	case err == nil:
+		w.forget(batch)
+		w.written.Add(uint64(len(batch)))
+		return nil, nil
+	case !refused(err):
+		return batch, err
+	}
+
+	for i := range batch {
+		err := w.store.CreateBatch(ctx, batch[i:i+1])
+		switch {
+		case err == nil:
+			w.written.Add(1)
+		case errors.Is(err, store.ErrRequestLogAlreadyExists):
+			// written before, e.g. by a replay interrupted by a crash
+		case errors.Is(err, store.ErrRequestLogInvalid):
+			w.rejected.Add(1)
+			w.logger.Error("request log rejected", "request_id", batch[i].RequestID, logging.KeyError, err)
+		default:
+			return batch[i:], err
+		}
+		w.forget(batch[i : i+1])
+	}
+
+	return nil, nil
+}
+
+func (w *Writer) drop(logs []store.RequestLog, err error) {
+	w.forget(logs)
+	w.lost.Add(uint64(len(logs)))
+	w.logger.Error("request logs lost", "count", len(logs), logging.KeyError, err)
+}
+
+func (w *Writer) forget(logs []store.RequestLog) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, log := range logs {
+		delete(w.pending, log.RequestID)
+	}
+}
+
+func refused(err error) bool {
+	return errors.Is(err, store.ErrRequestLogAlreadyExists) || errors.Is(err, store.ErrRequestLogInvalid)
+}
@@ -0,0 +1,257 @@
+package setup
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/leopardquick/zssf/logging"
+)
+
+const (
+	SecretSecurityCode           = "SECURITY_CODE"
+	SecretAccountVerificationKey = "ACCOUNT_VERIFICATION_KEY"
+	SecretTipsPassword           = "TIPS_PASSWORD"
+	SecretTipsPasswordQR         = "TIPS_PASSWORD_QR"
+	SecretJWTSigningKey          = "JWT_HS256_SECRET"
+	SecretRequestLogHashKey      = "REQUEST_LOG_HASH_KEY"
+)
+
+const (
+	SecretsProviderEnv       = "env"
+	SecretsProviderFile      = "file"
+	SecretsProviderEncrypted = "encrypted"
+)
+
+var ErrSecretNotFound = errors.New("secret not found")
+
+// SecretProvider resolves channel passwords and tokens at the time they are
+// used, so a rotated value is picked up without restarting the service.
+type SecretProvider interface {
+	Secret(ctx context.Context, name string) (string, error)
+}
+
+// NewSecretProvider returns the provider selected by cfg.SecretsProvider.
+func NewSecretProvider(cfg Config) (SecretProvider, error) {
+	switch cfg.SecretsProvider {
+	case "", SecretsProviderEnv:
+		return &EnvSecretProvider{Defaults: map[string]string{
+			SecretSecurityCode:           cfg.SecurityCode,
+			SecretAccountVerificationKey: cfg.AccountVerificationKey,
+			SecretTipsPassword:           cfg.TipsPassword,
+			SecretTipsPasswordQR:         cfg.TipsPasswordQR,
+			SecretJWTSigningKey:          cfg.JWTSigningKey,
+		}}, nil
+	case SecretsProviderFile:
+		return NewFileSecretProvider(cfg.SecretsDir), nil
+	case SecretsProviderEncrypted:
+		key, err := base64.StdEncoding.DecodeString(cfg.SecretsMasterKey)
+		if err != nil {
+			return nil, fmt.Errorf("decode secrets master key: %w", err)
+		}
+		return NewEncryptedFileSecretProvider(cfg.SecretsFile, key)
+	default:
+		return nil, fmt.Errorf("unknown secrets provider %q", cfg.SecretsProvider)
+	}
+}
+
+// EnvSecretProvider reads secrets from environment variables, falling back to
+// Defaults (typically values from the config file).
+type EnvSecretProvider struct {
+	Defaults map[string]string
+}
+
+func (p *EnvSecretProvider) Secret(ctx context.Context, name string) (string, error) {
+	if value := os.Getenv(name); value != "" {
+		return value, nil
+	}
+
+	if value := p.Defaults[name]; value != "" {
+		return value, nil
+	}
+
+	return "", fmt.Errorf("%w: %s", ErrSecretNotFound, name)
+}
+
+// FileSecretProvider reads one secret per file from a directory, the layout
+// used by Docker and Kubernetes secret mounts. Files are read on every call so
+// an updated mount takes effect immediately.
+type FileSecretProvider struct {
+	Dir string
+}
+
+func NewFileSecretProvider(dir string) *FileSecretProvider {
+	return &FileSecretProvider{Dir: dir}
+}
+
+func (p *FileSecretProvider) Secret(ctx context.Context, name string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(p.Dir, name))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", fmt.Errorf("%w: %s", ErrSecretNotFound, name)
+		}
+		return "", err
+	}
+
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// EncryptedFileSecretProvider reads a JSON object of secrets sealed with
+// AES-GCM. The file is decrypted again whenever its modification time changes.
+// If that fails, for instance because the file is being rewritten during a
+// rotation, the secrets loaded last are served until a reload succeeds.
+type EncryptedFileSecretProvider struct {
+	path string
+	aead cipher.AEAD
+
+	mu        sync.RWMutex
+	modTime   time.Time
+	secrets   map[string]string
+	reloadErr string
+}
+
+func NewEncryptedFileSecretProvider(path string, masterKey []byte) (*EncryptedFileSecretProvider, error) {
+	aead, err := newAEAD(masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &EncryptedFileSecretProvider{path: path, aead: aead}
+	if err := p.reloadIfChanged(); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func (p *EncryptedFileSecretProvider) Secret(ctx context.Context, name string) (string, error) {
+	reloadErr := p.reloadIfChanged()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if reloadErr != nil {
+		if p.secrets == nil {
+			return "", reloadErr
+		}
+		// log each distinct failure once rather than on every call
+		if reloadErr.Error() != p.reloadErr {
+			p.reloadErr = reloadErr.Error()
+			slog.WarnContext(ctx, "error reloading secrets file, using the secrets loaded last", "path", p.path, logging.KeyError, reloadErr)
+		}
+	} else {
+		p.reloadErr = ""
+	}
+
+	value, ok := p.secrets[name]
+	if !ok || value == "" {
+		return "", fmt.Errorf("%w: %s", ErrSecretNotFound, name)
+	}
+
+	return value, nil
+}
+
+func (p *EncryptedFileSecretProvider) reloadIfChanged() error {
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return fmt.Errorf("stat secrets file: %w", err)
+	}
+
+	p.mu.RLock()
+	unchanged := p.secrets != nil && info.ModTime().Equal(p.modTime)
+	p.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	data, err := os.ReadFile(p.path)
+	if err != nil {
+		return fmt.Errorf("read secrets file: %w", err)
+	}
+
+	secrets, err := decryptSecrets(p.aead, data)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	p.secrets = secrets
+	p.modTime = info.ModTime()
+	p.mu.Unlock()
+
+	return nil
+}
+
+// EncryptSecrets seals secrets with masterKey in the format read by
+// EncryptedFileSecretProvider: base64(nonce || ciphertext).
+func EncryptSecrets(masterKey []byte, secrets map[string]string) ([]byte, error) {
+	aead, err := newAEAD(masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := json.Marshal(secrets)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	sealed := aead.Seal(nonce, nonce, plaintext, nil)
+	encoded := make([]byte, base64.StdEncoding.EncodedLen(len(sealed)))
+	base64.StdEncoding.Encode(encoded, sealed)
+
+	return encoded, nil
+}
+
+func decryptSecrets(aead cipher.AEAD, data []byte) (map[string]string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("decode secrets file: %w", err)
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("secrets file is too short")
+	}
+
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt secrets file: %w", err)
+	}
+
+	var secrets map[string]string
+	if err := json.Unmarshal(plaintext, &secrets); err != nil {
+		return nil, fmt.Errorf("parse secrets file: %w", err)
+	}
+
+	return secrets, nil
+}
+
+func newAEAD(masterKey []byte) (cipher.AEAD, error) {
+	if len(masterKey) != 32 {
+		return nil, errors.New("secrets master key must be 32 bytes")
+	}
+
+	block, err := aes.NewCipher(masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
@@ -6,8 +6,27 @@ import (
 	"errors"
 )
 
+const (
+	AccountRoleOwner    = "OWNER"
+	AccountRoleJoint    = "JOINT"
+	AccountRoleDelegate = "DELEGATE"
+)
+
+type Account struct {
+	ID            int
+	AccountNumber string
+	Role          string
+	CanDebit      bool
+}
+
 type AccountStore interface {
 	ExistsByAccountNumber(ctx context.Context, accountNumber string) (bool, error)
+	// IsOwnedBy reports whether the user is an owner, joint holder or active
+	// delegate of the account.
+	IsOwnedBy(ctx context.Context, accountNumber, userID string) (bool, error)
+	// CanDebit reports whether the user may make payments from the account.
+	CanDebit(ctx context.Context, accountNumber, userID string) (bool, error)
+	ListByUserID(ctx context.Context, userID string) ([]Account, error)
 }
 
 type SQLAccountStore struct {
@@ -34,3 +53,68 @@ func (s *SQLAccountStore) ExistsByAccountNumber(ctx context.Context, accountNumb
 
 	return true, nil
 }
+
+func (s *SQLAccountStore) IsOwnedBy(ctx context.Context, accountNumber, userID string) (bool, error) {
+	return s.hasAccess(ctx, accountNumber, userID, false)
+}
+
+func (s *SQLAccountStore) CanDebit(ctx context.Context, accountNumber, userID string) (bool, error) {
+	return s.hasAccess(ctx, accountNumber, userID, true)
+}
+
+func (s *SQLAccountStore) hasAccess(ctx context.Context, accountNumber, userID string, debit bool) (bool, error) {
+	if s == nil || s.DB == nil {
+		return false, errors.New("db is not configured")
+	}
+
+	row := s.DB.QueryRowContext(ctx, `
+		SELECT 1
+		FROM account_users au
+		JOIN accounts a ON a.id = au.account_id
+		WHERE a.account_number = $1
+			AND au.user_id = $2
+			AND (au.expires_at IS NULL OR au.expires_at > NOW())
+			AND (NOT $3 OR au.can_debit)
+		LIMIT 1
+	`, accountNumber, userID, debit)
+
+	var found int
+	if err := row.Scan(&found); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (s *SQLAccountStore) ListByUserID(ctx context.Context, userID string) ([]Account, error) {
+	if s == nil || s.DB == nil {
+		return nil, errors.New("db is not configured")
+	}
+
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT a.id, a.account_number, au.role, au.can_debit
+		FROM account_users au
+		JOIN accounts a ON a.id = au.account_id
+		WHERE au.user_id = $1
+			AND (au.expires_at IS NULL OR au.expires_at > NOW())
+		ORDER BY a.id
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var accounts []Account
+	for rows.Next() {
+		var account Account
+		if err := rows.Scan(&account.ID, &account.AccountNumber, &account.Role, &account.CanDebit); err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, account)
+	}
+
+	return accounts, rows.Err()
+}
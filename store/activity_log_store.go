@@ -0,0 +1,61 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ActivityLog is one audit trail entry, such as a failed upstream call made
+// on a user's behalf.
+type ActivityLog struct {
+	UserID    string
+	RequestID string
+	TraceID   string
+	Message   string
+	CreatedAt time.Time
+}
+
+type ActivityLogStore interface {
+	// CreateBatch inserts logs in a single statement.
+	CreateBatch(ctx context.Context, logs []ActivityLog) error
+}
+
+type SQLActivityLogStore struct {
+	DB *sql.DB
+}
+
+func NewSQLActivityLogStore(db *sql.DB) *SQLActivityLogStore {
+	return &SQLActivityLogStore{DB: db}
+}
+
+func (s *SQLActivityLogStore) CreateBatch(ctx context.Context, logs []ActivityLog) error {
+	if s == nil || s.DB == nil {
+		return errors.New("db is not configured")
+	}
+	if len(logs) == 0 {
+		return nil
+	}
+
+	const columns = 5
+	values := make([]string, 0, len(logs))
+	args := make([]any, 0, len(logs)*columns)
+	for i, log := range logs {
+		n := i * columns
+		values = append(values, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4, n+5))
+
+		createdAt := log.CreatedAt
+		if createdAt.IsZero() {
+			createdAt = time.Now()
+		}
+		args = append(args, log.UserID, log.RequestID, log.TraceID, log.Message, createdAt)
+	}
+
+	_, err := s.DB.ExecContext(ctx, `
+		INSERT INTO activity_logs (user_id, request_id, trace_id, message, created_at)
+		VALUES `+strings.Join(values, ", "), args...)
+	return err
+}
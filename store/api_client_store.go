@@ -0,0 +1,127 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrAPIClientNotFound = errors.New("api client not found")
+	ErrNonceAlreadyUsed  = errors.New("nonce already used")
+)
+
+type APIClient struct {
+	ClientID   string
+	Name       string
+	Channel    string
+	APIKeyHash string
+	HMACSecret string
+	Active     bool
+	// AssertAnyUser lets the client act for any user it names in X-User-Id.
+	// Other clients may only act for the users linked to them in
+	// api_client_users.
+	AssertAnyUser bool
+	CreatedAt     time.Time
+}
+
+type APIClientStore interface {
+	GetByClientID(ctx context.Context, clientID string) (APIClient, error)
+	GetByAPIKeyHash(ctx context.Context, apiKeyHash string) (APIClient, error)
+	// ActsFor reports whether userID is linked to the client in
+	// api_client_users.
+	ActsFor(ctx context.Context, clientID, userID string) (bool, error)
+	// UseNonce records a nonce for the client and returns ErrNonceAlreadyUsed
+	// if it has been seen before.
+	UseNonce(ctx context.Context, clientID, nonce string) error
+	PurgeNonces(ctx context.Context, before time.Time) error
+}
+
+type SQLAPIClientStore struct {
+	DB *sql.DB
+}
+
+func NewSQLAPIClientStore(db *sql.DB) *SQLAPIClientStore {
+	return &SQLAPIClientStore{DB: db}
+}
+
+func (s *SQLAPIClientStore) GetByClientID(ctx context.Context, clientID string) (APIClient, error) {
+	return s.get(ctx, `WHERE client_id = $1`, clientID)
+}
+
+func (s *SQLAPIClientStore) GetByAPIKeyHash(ctx context.Context, apiKeyHash string) (APIClient, error) {
+	return s.get(ctx, `WHERE api_key_hash = $1`, apiKeyHash)
+}
+
+func (s *SQLAPIClientStore) get(ctx context.Context, where string, arg string) (APIClient, error) {
+	if s == nil || s.DB == nil {
+		return APIClient{}, errors.New("db is not configured")
+	}
+
+	row := s.DB.QueryRowContext(ctx, `
+		SELECT client_id, name, channel, COALESCE(api_key_hash, ''), COALESCE(hmac_secret, ''), active, assert_any_user, created_at
+		FROM api_clients
+		`+where, arg)
+
+	var client APIClient
+	if err := row.Scan(
+		&client.ClientID,
+		&client.Name,
+		&client.Channel,
+		&client.APIKeyHash,
+		&client.HMACSecret,
+		&client.Active,
+		&client.AssertAnyUser,
+		&client.CreatedAt,
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return APIClient{}, ErrAPIClientNotFound
+		}
+		return APIClient{}, err
+	}
+
+	return client, nil
+}
+
+func (s *SQLAPIClientStore) ActsFor(ctx context.Context, clientID, userID string) (bool, error) {
+	if s == nil || s.DB == nil {
+		return false, errors.New("db is not configured")
+	}
+
+	var linked bool
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM api_client_users WHERE client_id = $1 AND user_id = $2
+		)
+	`, clientID, userID).Scan(&linked)
+	return linked, err
+}
+
+func (s *SQLAPIClientStore) UseNonce(ctx context.Context, clientID, nonce string) error {
+	if s == nil || s.DB == nil {
+		return errors.New("db is not configured")
+	}
+
+	_, err := s.DB.ExecContext(ctx, `INSERT INTO api_client_nonces (client_id, nonce) VALUES ($1, $2)`, clientID, nonce)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && string(pqErr.Code) == "23505" {
+			return ErrNonceAlreadyUsed
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (s *SQLAPIClientStore) PurgeNonces(ctx context.Context, before time.Time) error {
+	if s == nil || s.DB == nil {
+		return errors.New("db is not configured")
+	}
+
+	_, err := s.DB.ExecContext(ctx, `DELETE FROM api_client_nonces WHERE created_at < $1`, before)
+	return err
+}
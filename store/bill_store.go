@@ -0,0 +1,139 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/leopardquick/zssf/model"
+)
+
+var ErrBillNotFound = errors.New("bill not found")
+
+// Bill is the enquiry result a payment is validated against. Values other
+// than the amounts are kept exactly as the gateway returned them. UserID is
+// the user who ran the enquiry; only they may pay against it.
+type Bill struct {
+	VDResponseID   string
+	UserID         string
+	ControlNo      string
+	Amount         model.Money
+	MinAmount      model.Money
+	Currency       string
+	PaymentOption  string
+	PaymentPlan    string
+	CreditAccount  string
+	BillExpireDate string
+	CreatedAt      time.Time
+}
+
+type BillStore interface {
+	Save(ctx context.Context, bill Bill) error
+	GetByVDResponseID(ctx context.Context, vdResponseID string) (Bill, error)
+	// DeleteCreatedBefore removes enquiries cached before cutoff.
+	DeleteCreatedBefore(ctx context.Context, cutoff time.Time) (int64, error)
+}
+
+type SQLBillStore struct {
+	DB *sql.DB
+}
+
+func NewSQLBillStore(db *sql.DB) *SQLBillStore {
+	return &SQLBillStore{DB: db}
+}
+
+func (s *SQLBillStore) Save(ctx context.Context, bill Bill) error {
+	if s == nil || s.DB == nil {
+		return errors.New("db is not configured")
+	}
+
+	_, err := s.DB.ExecContext(ctx, `
+		INSERT INTO bill_enquiries (
+			vd_response_id,
+			control_no,
+			amount,
+			min_amount,
+			currency,
+			payment_option,
+			payment_plan,
+			credit_account,
+			bill_expire_date,
+			user_id
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (vd_response_id) DO UPDATE SET
+			control_no = EXCLUDED.control_no,
+			amount = EXCLUDED.amount,
+			min_amount = EXCLUDED.min_amount,
+			currency = EXCLUDED.currency,
+			payment_option = EXCLUDED.payment_option,
+			payment_plan = EXCLUDED.payment_plan,
+			credit_account = EXCLUDED.credit_account,
+			bill_expire_date = EXCLUDED.bill_expire_date,
+			user_id = EXCLUDED.user_id,
+			created_at = NOW()
+	`,
+		bill.VDResponseID,
+		bill.ControlNo,
+		bill.Amount,
+		bill.MinAmount,
+		bill.Currency,
+		bill.PaymentOption,
+		bill.PaymentPlan,
+		bill.CreditAccount,
+		bill.BillExpireDate,
+		bill.UserID,
+	)
+	return err
+}
+
+func (s *SQLBillStore) GetByVDResponseID(ctx context.Context, vdResponseID string) (Bill, error) {
+	if s == nil || s.DB == nil {
+		return Bill{}, errors.New("db is not configured")
+	}
+
+	row := s.DB.QueryRowContext(ctx, `
+		SELECT vd_response_id, user_id, control_no, amount, min_amount, currency, payment_option, payment_plan,
+			credit_account, bill_expire_date, created_at
+		FROM bill_enquiries
+		WHERE vd_response_id = $1
+	`, vdResponseID)
+
+	var bill Bill
+	if err := row.Scan(
+		&bill.VDResponseID,
+		&bill.UserID,
+		&bill.ControlNo,
+		&bill.Amount,
+		&bill.MinAmount,
+		&bill.Currency,
+		&bill.PaymentOption,
+		&bill.PaymentPlan,
+		&bill.CreditAccount,
+		&bill.BillExpireDate,
+		&bill.CreatedAt,
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Bill{}, ErrBillNotFound
+		}
+		return Bill{}, err
+	}
+
+	bill.Amount.Currency = bill.Currency
+	bill.MinAmount.Currency = bill.Currency
+
+	return bill, nil
+}
+
+func (s *SQLBillStore) DeleteCreatedBefore(ctx context.Context, cutoff time.Time) (int64, error) {
+	if s == nil || s.DB == nil {
+		return 0, errors.New("db is not configured")
+	}
+
+	result, err := s.DB.ExecContext(ctx, `DELETE FROM bill_enquiries WHERE created_at < $1`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
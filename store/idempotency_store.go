@@ -0,0 +1,123 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type IdempotencyKey struct {
+	Key         string
+	RequestID   string
+	RequestPath string
+	RequestHash string
+	UserID      string
+	// ResponseStatus and ResponseBody are the response sent for the request,
+	// exactly as the client received it. ResponseStatus is 0 while the
+	// request is still in progress.
+	ResponseStatus int
+	ResponseBody   []byte
+	CreatedAt      time.Time
+}
+
+type IdempotencyStore interface {
+	// Reserve atomically claims key.Key. If the key was already claimed it
+	// returns the existing reservation and reserved=false, unless that
+	// reservation is for the same user and request, never completed and was
+	// made before staleBefore: the request holding it is presumed dead and the
+	// key is claimed again.
+	Reserve(ctx context.Context, key IdempotencyKey, staleBefore time.Time) (existing IdempotencyKey, reserved bool, err error)
+	// Complete stores the response sent for the request holding key so retries
+	// replay it.
+	Complete(ctx context.Context, key string, status int, body []byte) error
+	// Release drops a reservation that never completed so a retry runs the
+	// request again.
+	Release(ctx context.Context, key string) error
+}
+
+type SQLIdempotencyStore struct {
+	DB *sql.DB
+}
+
+func NewSQLIdempotencyStore(db *sql.DB) *SQLIdempotencyStore {
+	return &SQLIdempotencyStore{DB: db}
+}
+
+func (s *SQLIdempotencyStore) Reserve(ctx context.Context, key IdempotencyKey, staleBefore time.Time) (IdempotencyKey, bool, error) {
+	if s == nil || s.DB == nil {
+		return IdempotencyKey{}, false, errors.New("db is not configured")
+	}
+
+	result, err := s.DB.ExecContext(ctx, `
+		INSERT INTO idempotency_keys (idempotency_key, request_id, request_path, request_hash, user_id)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (idempotency_key) DO UPDATE
+		SET request_id = EXCLUDED.request_id,
+			created_at = NOW()
+		WHERE idempotency_keys.response_status = 0
+			AND idempotency_keys.request_hash = EXCLUDED.request_hash
+			AND idempotency_keys.user_id = EXCLUDED.user_id
+			AND idempotency_keys.created_at < $6
+	`, key.Key, key.RequestID, key.RequestPath, key.RequestHash, key.UserID, staleBefore)
+	if err != nil {
+		return IdempotencyKey{}, false, err
+	}
+
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return IdempotencyKey{}, false, err
+	}
+	if inserted == 1 {
+		return key, true, nil
+	}
+
+	row := s.DB.QueryRowContext(ctx, `
+		SELECT idempotency_key, request_id, request_path, request_hash, user_id, response_status, response_body, created_at
+		FROM idempotency_keys
+		WHERE idempotency_key = $1
+	`, key.Key)
+
+	var existing IdempotencyKey
+	if err := row.Scan(
+		&existing.Key,
+		&existing.RequestID,
+		&existing.RequestPath,
+		&existing.RequestHash,
+		&existing.UserID,
+		&existing.ResponseStatus,
+		&existing.ResponseBody,
+		&existing.CreatedAt,
+	); err != nil {
+		return IdempotencyKey{}, false, err
+	}
+
+	return existing, false, nil
+}
+
+func (s *SQLIdempotencyStore) Complete(ctx context.Context, key string, status int, body []byte) error {
+	if s == nil || s.DB == nil {
+		return errors.New("db is not configured")
+	}
+
+	_, err := s.DB.ExecContext(ctx, `
+		UPDATE idempotency_keys
+		SET response_status = $2,
+			response_body = $3,
+			completed_at = NOW()
+		WHERE idempotency_key = $1 AND response_status = 0
+	`, key, status, body)
+	return err
+}
+
+func (s *SQLIdempotencyStore) Release(ctx context.Context, key string) error {
+	if s == nil || s.DB == nil {
+		return errors.New("db is not configured")
+	}
+
+	_, err := s.DB.ExecContext(ctx, `
+		DELETE FROM idempotency_keys
+		WHERE idempotency_key = $1 AND response_status = 0
+	`, key)
+	return err
+}
@@ -0,0 +1,340 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/leopardquick/zssf/model"
+	"github.com/lib/pq"
+)
+
+const (
+	PaymentStatePending   = "PENDING"
+	PaymentStateSubmitted = "SUBMITTED"
+	PaymentStateSucceeded = "SUCCEEDED"
+	PaymentStateFailed    = "FAILED"
+	PaymentStateUnknown   = "UNKNOWN"
+)
+
+var (
+	ErrPaymentNotFound          = errors.New("payment not found")
+	ErrPaymentAlreadyExists     = errors.New("payment already exists")
+	ErrInvalidPaymentTransition = errors.New("invalid payment state transition")
+)
+
+// paymentTransitions lists the states each state may move to. A payment is
+// PENDING until it is about to be sent, SUBMITTED while the gateway call is in
+// flight, and UNKNOWN when the call ended without a usable answer.
+var paymentTransitions = map[string][]string{
+	PaymentStatePending:   {PaymentStateSubmitted, PaymentStateFailed},
+	PaymentStateSubmitted: {PaymentStateSucceeded, PaymentStateFailed, PaymentStateUnknown},
+	PaymentStateUnknown:   {PaymentStateSucceeded, PaymentStateFailed},
+}
+
+func CanTransitionPayment(from, to string) bool {
+	for _, allowed := range paymentTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+type Payment struct {
+	RequestID            string
+	UserID               string
+	ControlNo            string
+	VDResponseID         string
+	DebitAccount         string
+	CreditAccount        string
+	Amount               model.Money
+	Currency             string
+	State                string
+	GatewayStatusID      string
+	GatewayStatusMessage string
+	GatewayRefID         string
+	ReceiptNo            string
+	// PollAttempts counts the status queries that left the payment UNKNOWN;
+	// NextPollAt is when the reconciler queries it again. NeedsReview is set
+	// once it has given up and the payment must be resolved by hand.
+	PollAttempts int
+	NextPollAt   time.Time
+	NeedsReview  bool
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// PaymentUpdate carries the gateway details recorded with a state change.
+type PaymentUpdate struct {
+	GatewayStatusID      string
+	GatewayStatusMessage string
+	GatewayRefID         string
+	ReceiptNo            string
+}
+
+type PaymentStore interface {
+	Create(ctx context.Context, payment Payment) error
+	// Transition moves the payment from one state to another and fails with
+	// ErrInvalidPaymentTransition if the move is not allowed or the payment is
+	// no longer in the from state.
+	Transition(ctx context.Context, requestID, from, to string, update PaymentUpdate) error
+	GetByRequestID(ctx context.Context, requestID string) (Payment, error)
+	// ListByState returns up to limit payments in state that were last updated
+	// before updatedBefore, oldest first.
+	ListByState(ctx context.Context, state string, updatedBefore time.Time, limit int) ([]Payment, error)
+	// ListDueForPoll returns up to limit UNKNOWN payments whose next status
+	// query is due by now and that are not waiting for manual review, most
+	// overdue first.
+	ListDueForPoll(ctx context.Context, now time.Time, limit int) ([]Payment, error)
+	// DeferPoll records a status query that did not resolve an UNKNOWN
+	// payment: it counts the attempt, keeps message as the latest status
+	// message, sets when to query again and, with needsReview, stops further
+	// queries until the payment is resolved by hand.
+	DeferPoll(ctx context.Context, requestID string, nextPollAt time.Time, needsReview bool, message string) error
+}
+
+type SQLPaymentStore struct {
+	DB *sql.DB
+}
+
+func NewSQLPaymentStore(db *sql.DB) *SQLPaymentStore {
+	return &SQLPaymentStore{DB: db}
+}
+
+func (s *SQLPaymentStore) Create(ctx context.Context, payment Payment) error {
+	if s == nil || s.DB == nil {
+		return errors.New("db is not configured")
+	}
+
+	if payment.State == "" {
+		payment.State = PaymentStatePending
+	}
+
+	_, err := s.DB.ExecContext(ctx, `
+		INSERT INTO payments (
+			request_id,
+			user_id,
+			control_no,
+			vd_response_id,
+			debit_account,
+			credit_account,
+			amount,
+			currency,
+			state
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`,
+		payment.RequestID,
+		payment.UserID,
+		payment.ControlNo,
+		payment.VDResponseID,
+		payment.DebitAccount,
+		payment.CreditAccount,
+		payment.Amount,
+		payment.Currency,
+		payment.State,
+	)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && string(pqErr.Code) == "23505" {
+			return ErrPaymentAlreadyExists
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (s *SQLPaymentStore) Transition(ctx context.Context, requestID, from, to string, update PaymentUpdate) error {
+	if s == nil || s.DB == nil {
+		return errors.New("db is not configured")
+	}
+
+	if !CanTransitionPayment(from, to) {
+		return fmt.Errorf("%w: %s to %s", ErrInvalidPaymentTransition, from, to)
+	}
+
+	result, err := s.DB.ExecContext(ctx, `
+		UPDATE payments
+		SET state = $3,
+			gateway_status_id = COALESCE(NULLIF($4, ''), gateway_status_id),
+			gateway_status_message = COALESCE(NULLIF($5, ''), gateway_status_message),
+			gateway_ref_id = COALESCE(NULLIF($6, ''), gateway_ref_id),
+			receipt_no = COALESCE(NULLIF($7, ''), receipt_no),
+			updated_at = NOW()
+		WHERE request_id = $1 AND state = $2
+	`,
+		requestID,
+		from,
+		to,
+		update.GatewayStatusID,
+		update.GatewayStatusMessage,
+		update.GatewayRefID,
+		update.ReceiptNo,
+	)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return fmt.Errorf("%w: payment %s is not %s", ErrInvalidPaymentTransition, requestID, from)
+	}
+
+	return nil
+}
+
+func (s *SQLPaymentStore) GetByRequestID(ctx context.Context, requestID string) (Payment, error) {
+	if s == nil || s.DB == nil {
+		return Payment{}, errors.New("db is not configured")
+	}
+
+	row := s.DB.QueryRowContext(ctx, `
+		SELECT `+paymentColumns+`
+		FROM payments
+		WHERE request_id = $1
+	`, requestID)
+
+	payment, err := scanPayment(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Payment{}, ErrPaymentNotFound
+		}
+		return Payment{}, err
+	}
+
+	return payment, nil
+}
+
+func (s *SQLPaymentStore) ListByState(ctx context.Context, state string, updatedBefore time.Time, limit int) ([]Payment, error) {
+	if s == nil || s.DB == nil {
+		return nil, errors.New("db is not configured")
+	}
+
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT `+paymentColumns+`
+		FROM payments
+		WHERE state = $1 AND updated_at < $2
+		ORDER BY updated_at
+		LIMIT $3
+	`, state, updatedBefore, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var payments []Payment
+	for rows.Next() {
+		payment, err := scanPayment(rows)
+		if err != nil {
+			return nil, err
+		}
+		payments = append(payments, payment)
+	}
+
+	return payments, rows.Err()
+}
+
+func (s *SQLPaymentStore) ListDueForPoll(ctx context.Context, now time.Time, limit int) ([]Payment, error) {
+	if s == nil || s.DB == nil {
+		return nil, errors.New("db is not configured")
+	}
+
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT `+paymentColumns+`
+		FROM payments
+		WHERE state = $1 AND NOT needs_review AND next_poll_at <= $2
+		ORDER BY next_poll_at
+		LIMIT $3
+	`, PaymentStateUnknown, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var payments []Payment
+	for rows.Next() {
+		payment, err := scanPayment(rows)
+		if err != nil {
+			return nil, err
+		}
+		payments = append(payments, payment)
+	}
+
+	return payments, rows.Err()
+}
+
+func (s *SQLPaymentStore) DeferPoll(ctx context.Context, requestID string, nextPollAt time.Time, needsReview bool, message string) error {
+	if s == nil || s.DB == nil {
+		return errors.New("db is not configured")
+	}
+
+	result, err := s.DB.ExecContext(ctx, `
+		UPDATE payments
+		SET poll_attempts = poll_attempts + 1,
+			next_poll_at = $3,
+			needs_review = $4,
+			gateway_status_message = COALESCE(NULLIF($5, ''), gateway_status_message),
+			updated_at = NOW()
+		WHERE request_id = $1 AND state = $2
+	`,
+		requestID,
+		PaymentStateUnknown,
+		nextPollAt,
+		needsReview,
+		message,
+	)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return fmt.Errorf("%w: payment %s is not %s", ErrInvalidPaymentTransition, requestID, PaymentStateUnknown)
+	}
+
+	return nil
+}
+
+const paymentColumns = `request_id, user_id, control_no, vd_response_id, debit_account, credit_account, amount::TEXT,
+			currency, state, gateway_status_id, gateway_status_message, gateway_ref_id, receipt_no, poll_attempts, next_poll_at, needs_review,
+			created_at, updated_at`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanPayment(row rowScanner) (Payment, error) {
+	var payment Payment
+	err := row.Scan(
+		&payment.RequestID,
+		&payment.UserID,
+		&payment.ControlNo,
+		&payment.VDResponseID,
+		&payment.DebitAccount,
+		&payment.CreditAccount,
+		&payment.Amount,
+		&payment.Currency,
+		&payment.State,
+		&payment.GatewayStatusID,
+		&payment.GatewayStatusMessage,
+		&payment.GatewayRefID,
+		&payment.ReceiptNo,
+		&payment.PollAttempts,
+		&payment.NextPollAt,
+		&payment.NeedsReview,
+		&payment.CreatedAt,
+		&payment.UpdatedAt,
+	)
+	payment.Amount.Currency = payment.Currency
+	return payment, err
+}
@@ -5,6 +5,8 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/lib/pq"
@@ -13,6 +15,9 @@ import (
 var (
 	ErrRequestLogNotFound      = errors.New("request log not found")
 	ErrRequestLogAlreadyExists = errors.New("request log already exists")
+	// ErrRequestLogInvalid wraps errors for rows the database refuses, such
+	// as a constraint violation, which will fail again if retried.
+	ErrRequestLogInvalid = errors.New("request log rejected by database")
 )
 
 type RequestLog struct {
@@ -27,6 +32,7 @@ type RequestLog struct {
 	ResponseBody       json.RawMessage
 	ResponseHeaders    json.RawMessage
 	RequestReceipt     string
+	TraceID            string
 	CreatedAt          time.Time
 }
 
@@ -60,9 +66,10 @@ func (s *SQLRequestLogStore) Create(ctx context.Context, log RequestLog) error {
 			response_status_code,
 			response_body,
 			response_headers,
-			request_receipt
+			request_receipt,
+			trace_id
 		)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
 	`,
 		log.UserID,
 		log.RequestID,
@@ -75,18 +82,101 @@ func (s *SQLRequestLogStore) Create(ctx context.Context, log RequestLog) error {
 		log.ResponseBody,
 		log.ResponseHeaders,
 		log.RequestReceipt,
+		log.TraceID,
 	)
 	if err != nil {
-		var pqErr *pq.Error
-		if errors.As(err, &pqErr) && string(pqErr.Code) == "23505" {
-			return ErrRequestLogAlreadyExists
+		return requestLogError(err)
+	}
+
+	return nil
+}
+
+const requestLogColumns = 13
+
+// CreateBatch inserts logs in a single multi-row statement, keeping each
+// log's CreatedAt so rows written late carry the time of the request. If any
+// row is refused the whole batch is, with ErrRequestLogAlreadyExists or
+// ErrRequestLogInvalid; the caller can then insert the rows one by one.
+func (s *SQLRequestLogStore) CreateBatch(ctx context.Context, logs []RequestLog) error {
+	if s == nil || s.DB == nil {
+		return errors.New("db is not configured")
+	}
+	if len(logs) == 0 {
+		return nil
+	}
+
+	values := make([]string, 0, len(logs))
+	args := make([]any, 0, len(logs)*requestLogColumns)
+	for i, log := range logs {
+		placeholders := make([]string, requestLogColumns)
+		for j := range placeholders {
+			placeholders[j] = fmt.Sprintf("$%d", i*requestLogColumns+j+1)
 		}
-		return err
+		values = append(values, "("+strings.Join(placeholders, ", ")+")")
+
+		createdAt := log.CreatedAt
+		if createdAt.IsZero() {
+			createdAt = time.Now()
+		}
+		args = append(args,
+			log.UserID,
+			log.RequestID,
+			log.RequestMethod,
+			log.RequestPath,
+			log.RequestQuery,
+			log.RequestBody,
+			log.RequestHeaders,
+			log.ResponseStatusCode,
+			log.ResponseBody,
+			log.ResponseHeaders,
+			log.RequestReceipt,
+			log.TraceID,
+			createdAt,
+		)
+	}
+
+	_, err := s.DB.ExecContext(ctx, `
+		INSERT INTO request_logs (
+			user_id,
+			request_id,
+			request_method,
+			request_path,
+			request_query,
+			request_body,
+			request_headers,
+			response_status_code,
+			response_body,
+			response_headers,
+			request_receipt,
+			trace_id,
+			created_at
+		)
+		VALUES `+strings.Join(values, ", "), args...)
+	if err != nil {
+		return requestLogError(err)
 	}
 
 	return nil
 }
 
+// requestLogError maps unique violations to ErrRequestLogAlreadyExists and
+// other data and integrity errors to ErrRequestLogInvalid.
+func requestLogError(err error) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return err
+	}
+
+	switch {
+	case pqErr.Code == "23505":
+		return ErrRequestLogAlreadyExists
+	case pqErr.Code.Class() == "22", pqErr.Code.Class() == "23":
+		return fmt.Errorf("%w: %w", ErrRequestLogInvalid, err)
+	default:
+		return err
+	}
+}
+
 func (s *SQLRequestLogStore) GetByRequestID(ctx context.Context, requestID string) (RequestLog, error) {
 	if s == nil || s.DB == nil {
 		return RequestLog{}, errors.New("db is not configured")
@@ -94,7 +184,7 @@ func (s *SQLRequestLogStore) GetByRequestID(ctx context.Context, requestID strin
 
 	row := s.DB.QueryRowContext(ctx, `
 		SELECT request_id, user_id, request_method, request_path, request_query, request_body, request_headers,
-			response_status_code, response_body, response_headers, request_receipt, created_at
+			response_status_code, response_body, response_headers, request_receipt, trace_id, created_at
 		FROM request_logs
 		WHERE request_id = $1
 	`, requestID)
@@ -112,6 +202,7 @@ func (s *SQLRequestLogStore) GetByRequestID(ctx context.Context, requestID strin
 		&log.ResponseBody,
 		&log.ResponseHeaders,
 		&log.RequestReceipt,
+		&log.TraceID,
 		&log.CreatedAt,
 	); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
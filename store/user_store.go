@@ -0,0 +1,112 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+var ErrUserNotFound = errors.New("user not found")
+
+const (
+	UserStatusActive = "ACTIVE"
+	UserStatusLocked = "LOCKED"
+)
+
+type User struct {
+	UserID            string
+	PinHash           string
+	FailedPinAttempts int
+	Status            string
+}
+
+type UserStore interface {
+	GetByID(ctx context.Context, userID string) (User, error)
+	// IncrementFailedPinAttempts records a failed PIN entry and returns the
+	// new number of consecutive failures.
+	IncrementFailedPinAttempts(ctx context.Context, userID string) (int, error)
+	ResetFailedPinAttempts(ctx context.Context, userID string) error
+	UpdateStatus(ctx context.Context, userID, status string) error
+}
+
+type SQLUserStore struct {
+	DB *sql.DB
+}
+
+func NewSQLUserStore(db *sql.DB) *SQLUserStore {
+	return &SQLUserStore{DB: db}
+}
+
+func (s *SQLUserStore) GetByID(ctx context.Context, userID string) (User, error) {
+	if s == nil || s.DB == nil {
+		return User{}, errors.New("db is not configured")
+	}
+
+	row := s.DB.QueryRowContext(ctx, `
+		SELECT user_id, COALESCE(pin_hash, ''), failed_pin_attempts, status
+		FROM users
+		WHERE user_id = $1
+	`, userID)
+
+	var user User
+	if err := row.Scan(&user.UserID, &user.PinHash, &user.FailedPinAttempts, &user.Status); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return User{}, ErrUserNotFound
+		}
+		return User{}, err
+	}
+
+	return user, nil
+}
+
+func (s *SQLUserStore) IncrementFailedPinAttempts(ctx context.Context, userID string) (int, error) {
+	if s == nil || s.DB == nil {
+		return 0, errors.New("db is not configured")
+	}
+
+	row := s.DB.QueryRowContext(ctx, `
+		UPDATE users
+		SET failed_pin_attempts = failed_pin_attempts + 1
+		WHERE user_id = $1
+		RETURNING failed_pin_attempts
+	`, userID)
+
+	var attempts int
+	if err := row.Scan(&attempts); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrUserNotFound
+		}
+		return 0, err
+	}
+
+	return attempts, nil
+}
+
+func (s *SQLUserStore) ResetFailedPinAttempts(ctx context.Context, userID string) error {
+	return s.exec(ctx, `UPDATE users SET failed_pin_attempts = 0 WHERE user_id = $1`, userID)
+}
+
+func (s *SQLUserStore) UpdateStatus(ctx context.Context, userID, status string) error {
+	return s.exec(ctx, `UPDATE users SET status = $2 WHERE user_id = $1`, userID, status)
+}
+
+func (s *SQLUserStore) exec(ctx context.Context, query string, args ...any) error {
+	if s == nil || s.DB == nil {
+		return errors.New("db is not configured")
+	}
+
+	result, err := s.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/leopardquick/zssf/store"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// RequestLogs wraps s so every query runs in its own span.
+func RequestLogs(s store.RequestLogStore) store.RequestLogStore {
+	return &requestLogStore{next: s}
+}
+
+type requestLogStore struct {
+	next store.RequestLogStore
+}
+
+func (s *requestLogStore) Create(ctx context.Context, log store.RequestLog) error {
+	ctx, span := startSpan(ctx, "RequestLogStore.Create", semconv.DBSystemPostgreSQL, semconv.DBCollectionName("request_logs"), semconv.DBOperationName("INSERT"))
+	err := s.next.Create(ctx, log)
+	end(span, err)
+	return err
+}
+
+func (s *requestLogStore) GetByRequestID(ctx context.Context, requestID string) (store.RequestLog, error) {
+	ctx, span := startSpan(ctx, "RequestLogStore.GetByRequestID", semconv.DBSystemPostgreSQL, semconv.DBCollectionName("request_logs"), semconv.DBOperationName("SELECT"))
+	log, err := s.next.GetByRequestID(ctx, requestID)
+	end(span, err)
+	return log, err
+}
+
+// Accounts wraps s so every query runs in its own span.
+func Accounts(s store.AccountStore) store.AccountStore {
+	return &accountStore{next: s}
+}
+
+type accountStore struct {
+	next store.AccountStore
+}
+
+func (s *accountStore) ExistsByAccountNumber(ctx context.Context, accountNumber string) (bool, error) {
+	ctx, span := startSpan(ctx, "AccountStore.ExistsByAccountNumber", semconv.DBSystemPostgreSQL, semconv.DBCollectionName("accounts"), semconv.DBOperationName("SELECT"))
+	exists, err := s.next.ExistsByAccountNumber(ctx, accountNumber)
+	end(span, err)
+	return exists, err
+}
+
+func (s *accountStore) IsOwnedBy(ctx context.Context, accountNumber, userID string) (bool, error) {
+	ctx, span := startSpan(ctx, "AccountStore.IsOwnedBy", semconv.DBSystemPostgreSQL, semconv.DBCollectionName("account_users"), semconv.DBOperationName("SELECT"))
+	owned, err := s.next.IsOwnedBy(ctx, accountNumber, userID)
+	end(span, err)
+	return owned, err
+}
+
+func (s *accountStore) CanDebit(ctx context.Context, accountNumber, userID string) (bool, error) {
+	ctx, span := startSpan(ctx, "AccountStore.CanDebit", semconv.DBSystemPostgreSQL, semconv.DBCollectionName("account_users"), semconv.DBOperationName("SELECT"))
+	allowed, err := s.next.CanDebit(ctx, accountNumber, userID)
+	end(span, err)
+	return allowed, err
+}
+
+func (s *accountStore) ListByUserID(ctx context.Context, userID string) ([]store.Account, error) {
+	ctx, span := startSpan(ctx, "AccountStore.ListByUserID", semconv.DBSystemPostgreSQL, semconv.DBCollectionName("account_users"), semconv.DBOperationName("SELECT"))
+	accounts, err := s.next.ListByUserID(ctx, userID)
+	end(span, err)
+	return accounts, err
+}
@@ -0,0 +1,231 @@
+// Package validate checks request structs against rules declared in their
+// `validate` struct tags.
+//
+// Rules are comma separated and run in order; the first failing rule is
+// reported for the field. Every rule except required accepts an empty value.
+//
+//	required     the value must not be empty
+//	max=N, min=N the value has at most/at least N characters
+//	len=N        the value has exactly N characters
+//	digits       the value contains only 0-9
+//	msisdn       a Tanzanian mobile number; rewritten to 255XXXXXXXXX
+//	email        an email address
+//	amount       a positive decimal with at most two decimal places
+//	currency     a known ISO 4217 alphabetic code; rewritten to upper case
+//
+// String fields are checked and normalized in place. Other fields that
+// implement encoding.TextMarshaler, such as model.Money, are checked against
+// their text form but left unchanged. Field names in errors come from the
+// json tag.
+package validate
+
+import (
+	"encoding"
+	"fmt"
+	"net/mail"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/leopardquick/zssf/currency"
+)
+
+// FieldError explains why one request field was rejected.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+type rule func(value *string) string
+
+// Struct validates the struct v points to, normalizing fields in place, and
+// returns every failing field.
+func Struct(v any) []FieldError {
+	target := reflect.ValueOf(v)
+	if target.Kind() != reflect.Pointer || target.Elem().Kind() != reflect.Struct {
+		panic("validate: Struct needs a pointer to a struct")
+	}
+	target = target.Elem()
+
+	var errs []FieldError
+	for i := 0; i < target.NumField(); i++ {
+		field := target.Type().Field(i)
+		tag, ok := field.Tag.Lookup("validate")
+		if !ok {
+			continue
+		}
+
+		if field.Type.Kind() != reflect.String {
+			marshaler, ok := target.Field(i).Interface().(encoding.TextMarshaler)
+			if !ok {
+				continue
+			}
+			text, err := marshaler.MarshalText()
+			if err != nil {
+				errs = append(errs, FieldError{Field: jsonName(field), Message: "is invalid"})
+				continue
+			}
+			value := string(text)
+			if message := check(&value, tag); message != "" {
+				errs = append(errs, FieldError{Field: jsonName(field), Message: message})
+			}
+			continue
+		}
+
+		value := target.Field(i).String()
+		if message := check(&value, tag); message != "" {
+			errs = append(errs, FieldError{Field: jsonName(field), Message: message})
+			continue
+		}
+		target.Field(i).SetString(value)
+	}
+
+	return errs
+}
+
+func check(value *string, tag string) string {
+	*value = strings.TrimSpace(*value)
+
+	for _, name := range strings.Split(tag, ",") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(name), "=")
+
+		if name == "required" {
+			if *value == "" {
+				return "is required"
+			}
+			continue
+		}
+
+		if *value == "" {
+			return ""
+		}
+
+		r, err := lookup(name, arg)
+		if err != nil {
+			panic("validate: " + err.Error())
+		}
+		if message := r(value); message != "" {
+			return message
+		}
+	}
+
+	return ""
+}
+
+func lookup(name, arg string) (rule, error) {
+	switch name {
+	case "max", "min", "len":
+		n, err := strconv.Atoi(arg)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s needs a number, got %q", name, arg)
+		}
+		return length(name, n), nil
+	case "digits":
+		return digits, nil
+	case "msisdn":
+		return msisdn, nil
+	case "email":
+		return email, nil
+	case "amount":
+		return amount, nil
+	case "currency":
+		return currencyCode, nil
+	default:
+		return nil, fmt.Errorf("unknown rule %q", name)
+	}
+}
+
+func length(name string, n int) rule {
+	return func(value *string) string {
+		count := utf8.RuneCountInString(*value)
+		switch {
+		case name == "max" && count > n:
+			return fmt.Sprintf("must be at most %d characters", n)
+		case name == "min" && count < n:
+			return fmt.Sprintf("must be at least %d characters", n)
+		case name == "len" && count != n:
+			return fmt.Sprintf("must be %d characters", n)
+		}
+		return ""
+	}
+}
+
+func digits(value *string) string {
+	if !allDigits(*value) {
+		return "must contain only digits"
+	}
+	return ""
+}
+
+// msisdn accepts 0XXXXXXXXX, 255XXXXXXXXX, +255XXXXXXXXX and XXXXXXXXX, with
+// or without spaces, for mobile numbers starting 6 or 7.
+func msisdn(value *string) string {
+	number := strings.NewReplacer(" ", "", "-", "").Replace(*value)
+	number = strings.TrimPrefix(number, "+")
+
+	switch {
+	case len(number) == 12 && strings.HasPrefix(number, "255"):
+	case len(number) == 10 && strings.HasPrefix(number, "0"):
+		number = "255" + number[1:]
+	case len(number) == 9:
+		number = "255" + number
+	default:
+		return "must be a Tanzanian mobile number"
+	}
+
+	if !allDigits(number) || (number[3] != '6' && number[3] != '7') {
+		return "must be a Tanzanian mobile number"
+	}
+
+	*value = number
+	return ""
+}
+
+func email(value *string) string {
+	address, err := mail.ParseAddress(*value)
+	if err != nil || address.Address != *value {
+		return "must be a valid email address"
+	}
+	return ""
+}
+
+func amount(value *string) string {
+	whole, fraction, hasPoint := strings.Cut(*value, ".")
+	if whole == "" || !allDigits(whole) || (hasPoint && (fraction == "" || len(fraction) > 2 || !allDigits(fraction))) {
+		return "must be a positive amount with at most two decimal places"
+	}
+	if strings.Trim(whole+fraction, "0") == "" {
+		return "must be greater than zero"
+	}
+	return ""
+}
+
+func currencyCode(value *string) string {
+	code := strings.ToUpper(*value)
+	if len(code) != 3 || !currency.Valid(code) {
+		return "must be an ISO 4217 currency code"
+	}
+	*value = code
+	return ""
+}
+
+func allDigits(value string) bool {
+	if value == "" {
+		return false
+	}
+	for _, r := range value {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return field.Name
+	}
+	return name
+}